@@ -2,41 +2,82 @@ package traefik_umami_feeder
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
 	"fmt"
+	"html"
+	"io"
+	"mime"
 	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // ResponseWrapper wraps an http.ResponseWriter to intercept status codes and report requests to Umami.
 type ResponseWrapper struct {
 	http.ResponseWriter
 
-	request *http.Request
-	feeder  *UmamiFeeder
-	written bool // Track if WriteHeader was called
+	request    *http.Request
+	feeder     *UmamiFeeder
+	written    bool // Track if WriteHeader was called
+	untracked  bool // The request is not tracked, the wrapper only removes backend signal headers
+	deferred   bool // The request is submitted once the handler has finished, instead of on WriteHeader
+	statusCode int
+	bytes      int64
+	start      time.Time
+
+	backend      *backendSignals
+	purchaseRule *purchaseRule
+
+	bodyLimit      int // Maximum amount of bytes to capture, 0 to disable capturing
+	body           []byte
+	captureChecked bool
+	captured       string // Media type of the captured body, empty if the body is not captured
 }
 
-// WriteHeader intercepts the status code and submits the request to the Umami feeder if needed.
-// Then passes the call to the original WriteHeader method.
+// WriteHeader intercepts the status code, then passes the call to the original WriteHeader method.
 func (rw *ResponseWrapper) WriteHeader(statusCode int) {
+	rw.writeHeader(statusCode, nil)
+}
+
+// writeHeader records the status code, requests client hints on HTML responses and submits the request to
+// the Umami feeder unless the submission is deferred. Body is the first chunk of the response, used to detect
+// the content type if it's not set.
+func (rw *ResponseWrapper) writeHeader(statusCode int, body []byte) {
 	if rw.written {
 		return // Prevent multiple calls
 	}
 	rw.written = true
+	rw.statusCode = statusCode
 
-	if rw.feeder.shouldTrackStatus(statusCode) {
-		rw.feeder.submitToFeed(rw.request, statusCode)
+	if rw.feeder.backendEvents {
+		rw.backend = readBackendSignals(rw.Header(), rw.feeder.backendHeaderPrefix)
+	}
+
+	if !rw.untracked && rw.feeder.clientHints && responseMediaType(rw.Header(), body) == "text/html" {
+		rw.Header().Add("Accept-CH", clientHintsHeaders)
+	}
+
+	if !rw.deferred {
+		rw.submit()
 	}
 
 	// Continue with the original method.
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
-// Write intercepts the write call to ensure Flush is called after writing.
+// Write intercepts the write call to count the response size and ensure Flush is called after writing.
 func (rw *ResponseWrapper) Write(b []byte) (int, error) {
-	rw.WriteHeader(http.StatusOK)
+	rw.writeHeader(http.StatusOK, b)
+	rw.captureBody(b)
 
 	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
 
 	// Flush explicitly after write
 	// Required due to https://github.com/astappiev/traefik-umami-feeder/issues/7
@@ -45,6 +86,128 @@ func (rw *ResponseWrapper) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// captureBody copies the beginning of an HTML response, or of a JSON response matching a purchase rule,
+// until bodyLimit is reached.
+func (rw *ResponseWrapper) captureBody(b []byte) {
+	if rw.bodyLimit <= 0 || len(rw.body) >= rw.bodyLimit {
+		return
+	}
+
+	if !rw.captureChecked {
+		rw.captureChecked = true
+		mediaType := responseMediaType(rw.Header(), b)
+		if encoding := strings.ToLower(rw.Header().Get("Content-Encoding")); !isDecodableEncoding(encoding) {
+			// Brotli can't be decoded with the standard library, which is all plugins can use.
+			rw.feeder.debugf("not capturing %s, unsupported content encoding %s", rw.request.URL.Path, encoding)
+			return
+		}
+		if (mediaType == "text/html" && rw.feeder.extractTitle) || (isJsonMediaType(mediaType) && rw.purchaseRule != nil) {
+			rw.captured = mediaType
+		}
+	}
+
+	if rw.captured != "" {
+		n := min(len(b), rw.bodyLimit-len(rw.body))
+		rw.body = append(rw.body, b[:n]...)
+	}
+}
+
+// responseMediaType returns the media type of the response, detecting it from the body if it's not set.
+func responseMediaType(header http.Header, body []byte) string {
+	contentType := header.Get("Content-Type")
+	if contentType == "" && body != nil && header.Get("Content-Encoding") == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType
+}
+
+// isDecodableEncoding checks if a body with the given Content-Encoding can be decoded by decodedBody.
+func isDecodableEncoding(encoding string) bool {
+	switch encoding {
+	case "", "identity", "gzip", "x-gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// decodedBody returns the captured body, decompressed according to Content-Encoding.
+// As the body may be cut, the decompressed content is returned up to the first error.
+func (rw *ResponseWrapper) decodedBody() []byte {
+	var reader io.ReadCloser
+	var err error
+
+	switch encoding := strings.ToLower(rw.Header().Get("Content-Encoding")); encoding {
+	case "", "identity":
+		return rw.body
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(rw.body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(rw.body))
+		if err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(rw.body)), nil
+		}
+	default:
+		rw.feeder.debugf("unsupported content encoding %s", encoding)
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	decoded, _ := io.ReadAll(io.LimitReader(reader, int64(rw.bodyLimit)*8))
+	return decoded
+}
+
+var titleRegexp = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// title extracts the page title from the captured HTML response.
+func (rw *ResponseWrapper) title() string {
+	if rw.captured != "text/html" {
+		return ""
+	}
+
+	matches := titleRegexp.FindSubmatch(rw.decodedBody())
+	if matches == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(matches[1]))), " ")
+}
+
+// complete is called once the next handler has finished, it submits the request if the submission is deferred.
+func (rw *ResponseWrapper) complete() {
+	if !rw.written {
+		if rw.feeder.backendEvents {
+			// The handler didn't write anything, the headers are sent by the server afterward.
+			readBackendSignals(rw.Header(), rw.feeder.backendHeaderPrefix)
+		}
+		return
+	}
+
+	if rw.deferred {
+		rw.submit()
+	}
+}
+
+// submit submits the request to the Umami feeder if needed.
+func (rw *ResponseWrapper) submit() {
+	if rw.untracked {
+		return
+	}
+
+	if rw.backend != nil && rw.backend.skip {
+		rw.feeder.debugf("not reporting %s, skipped by backend", rw.request.URL.Path)
+		return
+	}
+
+	if rw.feeder.matchEventRules(rw.request, rw.statusCode) || rw.feeder.shouldTrackStatus(rw.statusCode) {
+		rw.feeder.submitToFeed(rw)
+	}
+}
+
 // Hijack implements the http.Hijacker interface.
 func (rw *ResponseWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := rw.ResponseWriter.(http.Hijacker); ok {
@@ -60,3 +223,28 @@ func (rw *ResponseWrapper) Flush() {
 		flusher.Flush()
 	}
 }
+
+var responseDataProperties = []string{"method", "response_time", "response_bytes", "protocol", "tls_version", "content_type"}
+
+// responseData returns the value of a response property, or nil if it is unknown.
+func (rw *ResponseWrapper) responseData(property string) any {
+	switch property {
+	case "method":
+		return rw.request.Method
+	case "response_time":
+		return time.Since(rw.start).Milliseconds()
+	case "response_bytes":
+		return rw.bytes
+	case "protocol":
+		return rw.request.Proto
+	case "tls_version":
+		if rw.request.TLS != nil {
+			return tls.VersionName(rw.request.TLS.Version)
+		}
+	case "content_type":
+		if mediaType, _, err := mime.ParseMediaType(rw.Header().Get("Content-Type")); err == nil {
+			return mediaType
+		}
+	}
+	return nil
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"math"
 	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
@@ -42,20 +44,128 @@ type Config struct {
 	UmamiPassword string `json:"umamiPassword"`
 	// UmamiTeamId defines a team, which will be used to retrieve the websites.
 	UmamiTeamId string `json:"umamiTeamId"`
+	// UmamiHeaders is a map of headers, which are added to every request sent to Umami, e.g. for an auth proxy.
+	// Authorization can only be set if neither UmamiToken nor UmamiUsername is set, as it carries the Umami token.
+	UmamiHeaders map[string]string `json:"umamiHeaders"`
+	// UmamiUserAgent is the User-Agent of requests sent to Umami.
+	UmamiUserAgent string `json:"umamiUserAgent"`
 
 	// Websites is a map of domain to websiteId, which is required if UmamiToken is not set.
 	// If both UmamiToken and Websites are set, Websites will override/extend domains retrieved from the API.
 	Websites map[string]string `json:"websites"`
+	// WebsiteHosts is a map of domain to the URL of the Umami instance, which collects events of the website.
+	// Domains which are not listed are collected by UmamiHost.
+	WebsiteHosts map[string]string `json:"websiteHosts"`
+	// WebsiteTags is a map of domain to the tag of the website, overriding Tag.
+	WebsiteTags map[string]string `json:"websiteTags"`
+	// Tag is added to every event, e.g. to distinguish environments or Traefik instances.
+	Tag string `json:"tag"`
 	// CreateNewWebsites when set to true, the plugin will create new websites using API, UmamiToken is required.
 	CreateNewWebsites bool `json:"createNewWebsites"`
+	// Reports is a list of goals and funnel reports, which are created or updated in Umami for every website
+	// the plugin fetches or creates. UmamiToken is required.
+	Reports []ReportConfig `json:"reports"`
 
 	// TrackErrors defines whether errors (status codes >= 400) should be tracked.
 	TrackErrors bool `json:"trackErrors"`
+	// TrackRedirects defines how redirects (status codes 3xx) are tracked: `pageview` of the source URL,
+	// `skip` to not track them, or `event` to send a `redirect` event with `from`, `to` and `status_code` data.
+	TrackRedirects string `json:"trackRedirects"`
 	// TrackAllResources defines whether all requests for any resource should be tracked.
 	// By default, only requests that are believed to contain content are tracked.
 	TrackAllResources bool `json:"trackAllResources"`
 	// TrackExtensions defines an alternative list of file extensions that should be tracked.
 	TrackExtensions []string `json:"trackExtensions"`
+	// QueryParams defines how query parameters of tracked URLs are handled:
+	// `keep` all, `drop` all, `allow` only or `deny` the parameters matching QueryParamsList.
+	QueryParams string `json:"queryParams"`
+	// QueryParamsList is a list of glob patterns of query parameter names, see [path.Match].
+	QueryParamsList []string `json:"queryParamsList"`
+	// PathTemplates is a list of route templates (e.g. `/users/:id/orders/:orderId`), URLs matching a template
+	// are reported as the template, each `:name` or `{name}` segment matches any value.
+	PathTemplates []string `json:"pathTemplates"`
+	// PathIds is a list of kinds of path segments collapsed to `:id`, if no template matches:
+	// `numeric`, `uuid`, `hex` and `slug`.
+	PathIds []string `json:"pathIds"`
+	// PathParamsAsData when set to true, the raw values of collapsed path segments are sent as event data.
+	PathParamsAsData bool `json:"pathParamsAsData"`
+	// StripClickIds when set to true, click identifiers of ad networks (e.g. `gclid`, `fbclid`) are removed from URLs.
+	StripClickIds bool `json:"stripClickIds"`
+	// ClientHints when set to true, User-Agent Client Hints are requested on HTML responses
+	// and used to fill the screen size and restore details of reduced user agents.
+	ClientHints bool `json:"clientHints"`
+	// ExtractTitle when set to true, the page title is extracted from the beginning of HTML responses.
+	// Only uncompressed, gzip and deflate responses are supported, brotli (`br`) responses have no title.
+	ExtractTitle bool `json:"extractTitle"`
+	// ExtractTitleLimit is the amount of bytes of HTML responses inspected to find the title.
+	ExtractTitleLimit int `json:"extractTitleLimit"`
+	// DataFromResponse is a list of response properties attached to every event as data, supported values are:
+	// `method`, `response_time` (milliseconds), `response_bytes`, `protocol`, `tls_version` and `content_type`.
+	DataFromResponse []string `json:"dataFromResponse"`
+	// DataFromHeaders is a map of request header name to event data property name.
+	DataFromHeaders map[string]string `json:"dataFromHeaders"`
+	// DataFromCookies is a map of request cookie name to event data property name.
+	DataFromCookies map[string]string `json:"dataFromCookies"`
+	// Events is a list of rules, which send custom events for matching requests.
+	Events []EventRule `json:"events"`
+	// Purchases is a list of rules, which send purchase events with revenue extracted from JSON responses.
+	Purchases []PurchaseRule `json:"purchases"`
+	// PurchaseBodyLimit is the maximum amount of bytes captured from responses matching purchase rules.
+	PurchaseBodyLimit int `json:"purchaseBodyLimit"`
+	// Conversions is a list of rules, which send conversion events when visitors reach goal URLs.
+	Conversions []ConversionRule `json:"conversions"`
+	// ConversionsMaxVisitors is the maximum number of visitors whose visited steps are remembered.
+	ConversionsMaxVisitors int `json:"conversionsMaxVisitors"`
+	// DistinctIdHeader is a request header holding the ID of the visitor, e.g. `Remote-User` set by forward auth.
+	DistinctIdHeader string `json:"distinctIdHeader"`
+	// DistinctIdCookie is a cookie holding the ID of the visitor, used if DistinctIdHeader is not present.
+	DistinctIdCookie string `json:"distinctIdCookie"`
+	// Jwt configures extraction of the distinct ID and event data from JSON Web Token claims.
+	Jwt *JwtConfig `json:"jwt"`
+	// SessionData configures session properties sent with an identify call once per visitor session.
+	SessionData *SessionDataConfig `json:"sessionData"`
+	// HashData is a list of event data properties, whose values are hashed before they are sent.
+	HashData []string `json:"hashData"`
+	// HashSalt is prepended to values, including distinct IDs, before hashing. It is required if values are hashed.
+	HashSalt string `json:"hashSalt"`
+
+	// TrailingSlash defines the trailing slash policy of tracked paths: `keep`, `add` (except for files with an
+	// extension) or `remove`.
+	TrailingSlash string `json:"trailingSlash"`
+	// LowercasePaths when set to true, tracked paths are lowercased.
+	LowercasePaths bool `json:"lowercasePaths"`
+	// IndexFiles is a list of file names removed from the end of tracked paths, e.g. `index.html`.
+	IndexFiles []string `json:"indexFiles"`
+	// NormalizeEncoding when set to true, tracked paths are re-encoded, so that equivalent percent-encodings match.
+	NormalizeEncoding bool `json:"normalizeEncoding"`
+	// MergeSlashes when set to true, duplicate slashes in tracked paths are merged.
+	MergeSlashes bool `json:"mergeSlashes"`
+
+	// StripReferrerQuery when set to true, query and fragment are removed from referrers.
+	StripReferrerQuery bool `json:"stripReferrerQuery"`
+	// IgnoreSelfReferrer when set to true, referrers of the same website or of InternalDomains are not reported.
+	IgnoreSelfReferrer bool `json:"ignoreSelfReferrer"`
+	// InternalDomains is a list of domains, including their subdomains, which are not reported as referrers.
+	InternalDomains []string `json:"internalDomains"`
+	// ReferrerFromOrigin when set to true, the Origin header is used as referrer of non-GET requests without Referer.
+	ReferrerFromOrigin bool `json:"referrerFromOrigin"`
+	// PreferContentLanguage when set to true, the language of the response (Content-Language header)
+	// is reported instead of the language requested by the browser.
+	PreferContentLanguage bool `json:"preferContentLanguage"`
+	// LocalePrefixes is a list of locales used as the first path segment (e.g. `de` for `/de/about`),
+	// the language of such requests is taken from the path.
+	LocalePrefixes []string `json:"localePrefixes"`
+	// LocalePattern is a regular expression matched against the beginning of the path, an alternative to
+	// LocalePrefixes, its first capture group is the locale (e.g. `^/([a-z]{2}(?:-[a-z]{2})?)(?:/|$)`).
+	LocalePattern string `json:"localePattern"`
+	// StripLocalePrefix when set to true, the detected locale prefix is removed from tracked URLs.
+	StripLocalePrefix bool `json:"stripLocalePrefix"`
+
+	// BackendEvents when set to true, the backend can send events with response headers: `X-Umami-Event` (event
+	// names), `X-Umami-Data-<Property>` (event data) and `X-Umami-Skip` (don't track), the headers are removed.
+	BackendEvents bool `json:"backendEvents"`
+	// BackendHeaderPrefix is the prefix of backend event headers.
+	BackendHeaderPrefix string `json:"backendHeaderPrefix"`
 
 	// IgnoreUserAgents is a list of user agents to ignore.
 	IgnoreUserAgents []string `json:"ignoreUserAgents"`
@@ -85,12 +195,58 @@ func CreateConfig() *Config {
 		UmamiUsername: "",
 		UmamiPassword: "",
 		UmamiTeamId:   "",
+		UmamiHeaders:  map[string]string{},
 
 		Websites:          map[string]string{},
+		WebsiteHosts:      map[string]string{},
+		WebsiteTags:       map[string]string{},
+		Tag:               "",
 		CreateNewWebsites: false,
+		Reports:           []ReportConfig{},
 
+		TrackRedirects:    trackRedirectsPageview,
 		TrackAllResources: false,
 		TrackExtensions:   []string{},
+		QueryParams:       queryParamsKeep,
+		QueryParamsList:   []string{},
+		StripClickIds:     false,
+		ClientHints:       false,
+		ExtractTitle:      false,
+		ExtractTitleLimit: 16 * 1024,
+		DataFromResponse:  []string{},
+		DataFromHeaders:   map[string]string{},
+		DataFromCookies:   map[string]string{},
+		Events:            []EventRule{},
+		Purchases:         []PurchaseRule{},
+		PurchaseBodyLimit: 64 * 1024,
+		Conversions:       []ConversionRule{},
+		DistinctIdHeader:  "",
+		DistinctIdCookie:  "",
+		HashData:          []string{},
+		HashSalt:          "",
+
+		TrailingSlash:     trailingSlashKeep,
+		LowercasePaths:    false,
+		IndexFiles:        []string{},
+		NormalizeEncoding: false,
+		MergeSlashes:      false,
+
+		StripReferrerQuery: false,
+		IgnoreSelfReferrer: false,
+		InternalDomains:    []string{},
+		ReferrerFromOrigin: false,
+
+		PreferContentLanguage: false,
+		LocalePrefixes:        []string{},
+		LocalePattern:         "",
+		StripLocalePrefix:     false,
+
+		BackendEvents:       false,
+		BackendHeaderPrefix: "X-Umami-",
+
+		PathTemplates:    []string{},
+		PathIds:          []string{},
+		PathParamsAsData: false,
 
 		IgnoreUserAgents: []string{},
 		IgnoreURLs:       []string{},
@@ -107,21 +263,76 @@ type UmamiFeeder struct {
 	isDebug    bool
 	isEnabled  bool
 	logHandler *log.Logger
-	queue      chan *UmamiEvent
+	queue      chan *SendBody
+
+	truncations int64 // Number of values truncated to Umami limits, accessed atomically
 
 	batchSize    int
 	batchMaxWait time.Duration
 
 	umamiHost         string
 	umamiToken        string
+	capabilities      map[string]*umamiCapabilities
 	umamiTeamId       string
+	headers           http.Header
 	websites          map[string]string
 	websitesMutex     sync.RWMutex
+	websiteHosts      map[string]string
+	websiteTags       map[string]string
+	tag               string
 	createNewWebsites bool
+	reports           []ReportConfig
 
 	trackErrors       bool
+	trackRedirects    string
 	trackAllResources bool
 	trackExtensions   []string
+	queryParams       string
+	queryParamsList   []string
+	stripClickIds     bool
+	clientHints       bool
+	extractTitle      bool
+	bodyLimit         int
+	dataFromResponse  []string
+	dataFromHeaders   map[string]string
+	dataFromCookies   map[string]string
+	eventRules        []*eventRule
+	purchaseRules     []*purchaseRule
+	purchaseBodyLimit int
+	conversionRules   []*conversionRule
+	visitors          *ttlCache
+	visitorSalt       string
+	distinctIdHeader  string
+	distinctIdCookie  string
+	jwt               *JwtConfig
+	jwtKeys           []*jwtKey
+	sessionData       *SessionDataConfig
+	identified        *ttlCache
+	hashData          []string
+	hashSalt          string
+
+	trailingSlash     string
+	lowercasePaths    bool
+	indexFiles        []string
+	normalizeEncoding bool
+	mergeSlashes      bool
+
+	stripReferrerQuery bool
+	ignoreSelfReferrer bool
+	internalDomains    []string
+	referrerFromOrigin bool
+
+	preferContentLanguage bool
+	localePrefixes        []string
+	localePattern         *regexp.Regexp
+	stripLocalePrefix     bool
+
+	backendEvents       bool
+	backendHeaderPrefix string
+
+	pathTemplates    []*pathTemplate
+	pathIds          []string
+	pathParamsAsData bool
 
 	ignoreHosts      []string
 	ignoreUserAgents []string
@@ -139,20 +350,62 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		isEnabled:  config.Enabled && !config.Disabled,
 		logHandler: log.New(os.Stdout, "", 0),
 
-		queue:        make(chan *UmamiEvent, config.QueueSize),
+		queue:        make(chan *SendBody, config.QueueSize),
 		batchSize:    config.BatchSize,
 		batchMaxWait: config.BatchMaxWait,
 
 		umamiHost:         config.UmamiHost,
 		umamiToken:        config.UmamiToken,
+		capabilities:      map[string]*umamiCapabilities{},
 		umamiTeamId:       config.UmamiTeamId,
+		headers:           make(http.Header),
 		websites:          config.Websites,
 		websitesMutex:     sync.RWMutex{},
+		websiteHosts:      map[string]string{},
+		websiteTags:       map[string]string{},
+		tag:               config.Tag,
 		createNewWebsites: config.CreateNewWebsites,
+		reports:           config.Reports,
 
 		trackErrors:       config.TrackErrors,
+		trackRedirects:    config.TrackRedirects,
 		trackAllResources: config.TrackAllResources,
 		trackExtensions:   config.TrackExtensions,
+		queryParams:       config.QueryParams,
+		queryParamsList:   config.QueryParamsList,
+		stripClickIds:     config.StripClickIds,
+		clientHints:       config.ClientHints,
+		extractTitle:      config.ExtractTitle,
+		purchaseBodyLimit: config.PurchaseBodyLimit,
+		dataFromResponse:  config.DataFromResponse,
+		dataFromHeaders:   config.DataFromHeaders,
+		dataFromCookies:   config.DataFromCookies,
+		distinctIdHeader:  config.DistinctIdHeader,
+		distinctIdCookie:  config.DistinctIdCookie,
+		jwt:               config.Jwt,
+		sessionData:       config.SessionData,
+		hashData:          config.HashData,
+		hashSalt:          config.HashSalt,
+
+		trailingSlash:     config.TrailingSlash,
+		lowercasePaths:    config.LowercasePaths,
+		indexFiles:        config.IndexFiles,
+		normalizeEncoding: config.NormalizeEncoding,
+		mergeSlashes:      config.MergeSlashes,
+
+		stripReferrerQuery: config.StripReferrerQuery,
+		ignoreSelfReferrer: config.IgnoreSelfReferrer,
+		internalDomains:    config.InternalDomains,
+		referrerFromOrigin: config.ReferrerFromOrigin,
+
+		preferContentLanguage: config.PreferContentLanguage,
+		localePrefixes:        config.LocalePrefixes,
+		stripLocalePrefix:     config.StripLocalePrefix,
+
+		backendEvents:       config.BackendEvents,
+		backendHeaderPrefix: config.BackendHeaderPrefix,
+
+		pathParamsAsData: config.PathParamsAsData,
 
 		ignoreHosts:      config.IgnoreHosts,
 		ignoreUserAgents: config.IgnoreUserAgents,
@@ -161,6 +414,23 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		headerIp:         config.HeaderIp,
 	}
 
+	for domain, tag := range config.WebsiteTags {
+		h.websiteTags[parseDomainFromHost(domain)] = tag
+	}
+	for domain, host := range config.WebsiteHosts {
+		h.websiteHosts[parseDomainFromHost(domain)] = strings.TrimSuffix(host, "/")
+	}
+
+	if h.extractTitle {
+		h.bodyLimit = config.ExtractTitleLimit
+	}
+	for name, value := range config.UmamiHeaders {
+		h.headers.Set(name, value)
+	}
+	if config.UmamiUserAgent != "" {
+		h.headers.Set("User-Agent", config.UmamiUserAgent)
+	}
+
 	if h.isEnabled {
 		h.isEnabled = false // Disable until connection and config verification is done.
 		go h.retryConnection(ctx, config)
@@ -174,12 +444,30 @@ func (h *UmamiFeeder) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		// If the resource should be reported, we wrap the response writer and check the status code before reporting
 		responseWrapper := &ResponseWrapper{
 			ResponseWriter: rw,
-			request:        req,
+			request:        h.normalizedRequest(req),
 			feeder:         h,
+			start:          time.Now(),
+			bodyLimit:      h.bodyLimit,
+			deferred:       h.deferSubmission(),
+		}
+
+		if responseWrapper.purchaseRule = h.matchPurchaseRule(responseWrapper.request); responseWrapper.purchaseRule != nil {
+			responseWrapper.bodyLimit = max(responseWrapper.bodyLimit, h.purchaseBodyLimit)
+			responseWrapper.deferred = true
 		}
 
 		// Continue with next handler.
 		h.next.ServeHTTP(responseWrapper, req)
+		responseWrapper.complete()
+		return
+	}
+
+	if h.backendEvents {
+		// Backend signal headers must not reach the client, even if the request is not tracked or the plugin
+		// is not connected yet.
+		responseWrapper := &ResponseWrapper{ResponseWriter: rw, request: req, feeder: h, untracked: true}
+		h.next.ServeHTTP(responseWrapper, req)
+		responseWrapper.complete()
 		return
 	}
 
@@ -236,8 +524,29 @@ func (h *UmamiFeeder) connect(ctx context.Context, config *Config) error {
 		return errors.New("umamiHost is not set")
 	}
 
+	capabilities, err := probeCapabilities(ctx, h.umamiHost, h.headers)
+	if err != nil {
+		return fmt.Errorf("failed to reach Umami: %w", err)
+	}
+	h.capabilities[h.umamiHost] = capabilities
+	h.infof("Umami %s: %s", h.umamiHost, capabilities)
+
+	for _, host := range h.websiteHosts {
+		if _, ok := h.capabilities[host]; ok {
+			continue
+		}
+
+		capabilities, err := probeCapabilities(ctx, host, h.headers)
+		if err != nil {
+			h.error("failed to reach Umami " + host + ": " + err.Error())
+			continue
+		}
+		h.capabilities[host] = capabilities
+		h.infof("Umami %s: %s", host, capabilities)
+	}
+
 	if config.UmamiUsername != "" && config.UmamiPassword != "" {
-		token, err := getToken(ctx, h.umamiHost, config.UmamiUsername, config.UmamiPassword)
+		token, err := getToken(ctx, h.umamiHost, h.headers, config.UmamiUsername, config.UmamiPassword)
 		if err != nil {
 			return fmt.Errorf("failed to get token: %w", err)
 		}
@@ -253,27 +562,211 @@ func (h *UmamiFeeder) connect(ctx context.Context, config *Config) error {
 	if h.umamiToken == "" && h.createNewWebsites {
 		return errors.New("umamiToken is required to create new websites")
 	}
+	if h.umamiToken == "" && len(h.reports) > 0 {
+		return errors.New("umamiToken is required to sync reports")
+	}
 
 	if h.umamiToken != "" {
-		websites, err := fetchWebsites(ctx, h.umamiHost, h.umamiToken, h.umamiTeamId)
+		websites, err := fetchWebsites(ctx, h.umamiHost, h.headers, h.umamiToken, h.umamiTeamId)
 		if err != nil {
 			return fmt.Errorf("failed to fetch websites: %w", err)
 		}
 
 		h.websitesMutex.Lock()
 		for _, website := range *websites {
+			if !h.isManagedHost(website.Domain) {
+				continue // The website belongs to another Umami instance.
+			}
 			if _, ok := h.websites[website.Domain]; !ok {
 				h.websites[website.Domain] = website.ID
 			}
 		}
 		h.websitesMutex.Unlock()
 		h.debugf("websites fetched: %v", h.websites)
+
+		if len(h.reports) > 0 {
+			h.websitesMutex.RLock()
+			websites := maps.Clone(h.websites)
+			h.websitesMutex.RUnlock()
+
+			for domain, websiteId := range websites {
+				if !h.isManagedHost(domain) {
+					continue
+				}
+				if err := syncReports(ctx, h, websiteId, domain); err != nil {
+					h.error("failed to sync reports for " + domain + ": " + err.Error())
+				}
+			}
+		}
 	}
 
 	return nil
 }
 
 func (h *UmamiFeeder) verifyConfig(config *Config) error {
+	for name := range config.UmamiHeaders {
+		// API calls are authorized with the Umami token, which would replace the header.
+		if http.CanonicalHeaderKey(name) == "Authorization" && (config.UmamiToken != "" || config.UmamiUsername != "") {
+			return errors.New("umamiHeaders Authorization can't be combined with umamiToken or umamiUsername")
+		}
+	}
+
+	for _, report := range config.Reports {
+		if err := report.verify(); err != nil {
+			return fmt.Errorf("invalid report %s: %w", report.Name, err)
+		}
+	}
+
+	for domain, host := range config.WebsiteHosts {
+		u, err := url.Parse(host)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid websiteHost given for %s: %s", domain, host)
+		}
+
+		domain = parseDomainFromHost(domain)
+
+		// The API token is only valid for UmamiHost, websites of other instances can't be created or fetched.
+		if _, ok := config.Websites[domain]; !ok {
+			return fmt.Errorf("websiteHost given for %s requires its websiteId in websites", domain)
+		}
+	}
+
+	switch config.TrackRedirects {
+	case "", trackRedirectsPageview, trackRedirectsSkip, trackRedirectsEvent:
+	default:
+		return fmt.Errorf("unknown trackRedirects mode %s", config.TrackRedirects)
+	}
+
+	if config.LocalePattern != "" {
+		r, err := regexp.Compile(config.LocalePattern)
+		if err != nil {
+			return fmt.Errorf("failed to compile localePattern %s: %w", config.LocalePattern, err)
+		}
+		if r.NumSubexp() < 1 {
+			return fmt.Errorf("localePattern %s requires a capture group", config.LocalePattern)
+		}
+
+		h.localePattern = r
+	}
+
+	if config.BackendEvents && config.BackendHeaderPrefix == "" {
+		return errors.New("backendEvents requires backendHeaderPrefix")
+	}
+
+	if err := verifyQueryParams(config.QueryParams, config.QueryParamsList); err != nil {
+		return err
+	}
+
+	switch config.TrailingSlash {
+	case "", trailingSlashKeep, trailingSlashAdd, trailingSlashRemove:
+	default:
+		return fmt.Errorf("unknown trailingSlash policy %s", config.TrailingSlash)
+	}
+
+	pathTemplates := make([]*pathTemplate, 0, len(config.PathTemplates))
+	for _, template := range config.PathTemplates {
+		compiled, err := compilePathTemplate(template, config.LowercasePaths)
+		if err != nil {
+			return err
+		}
+
+		pathTemplates = append(pathTemplates, compiled)
+	}
+	if err := verifyPathIds(config.PathIds); err != nil {
+		return err
+	}
+	h.pathTemplates = pathTemplates
+	h.pathIds = config.PathIds
+
+	for _, rule := range config.Events {
+		compiled, err := compileEventRule(rule)
+		if err != nil {
+			return fmt.Errorf("invalid event %s: %w", rule.Name, err)
+		}
+
+		h.eventRules = append(h.eventRules, compiled)
+	}
+
+	for _, rule := range config.Purchases {
+		compiled, err := compilePurchaseRule(rule)
+		if err != nil {
+			return fmt.Errorf("invalid purchase rule %s: %w", rule.URL, err)
+		}
+
+		h.purchaseRules = append(h.purchaseRules, compiled)
+	}
+	if len(h.purchaseRules) > 0 && config.PurchaseBodyLimit <= 0 {
+		return errors.New("purchaseBodyLimit must be positive")
+	}
+
+	// Without a salt, hashes of usernames or emails can be reversed with a dictionary.
+	hashed := config.DistinctIdHeader != "" || config.DistinctIdCookie != "" || config.Jwt != nil || len(config.HashData) > 0
+	if hashed && config.HashSalt == "" {
+		return errors.New("hashSalt is required to hash distinct IDs and hashData")
+	}
+
+	if config.Jwt != nil {
+		if config.Jwt.Header == "" && config.Jwt.Cookie == "" {
+			return errors.New("jwt requires header or cookie")
+		}
+		if config.Jwt.DistinctIdClaim == "" {
+			config.Jwt.DistinctIdClaim = "sub"
+		}
+		if config.Jwt.JwksFile != "" {
+			keys, err := loadJwks(config.Jwt.JwksFile)
+			if err != nil {
+				return fmt.Errorf("failed to load jwksFile %s: %w", config.Jwt.JwksFile, err)
+			}
+			h.jwtKeys = keys
+		}
+	}
+
+	if config.SessionData != nil {
+		if len(config.SessionData.Claims) > 0 && config.Jwt == nil {
+			return errors.New("sessionData claims require jwt to be configured")
+		}
+		if config.SessionData.Ttl <= 0 {
+			config.SessionData.Ttl = 30 * time.Minute
+		}
+		if config.SessionData.MaxSessions <= 0 {
+			config.SessionData.MaxSessions = 10000
+		}
+		h.identified = newTTLCache(config.SessionData.MaxSessions, config.SessionData.Ttl)
+	}
+
+	window := time.Duration(0)
+	for _, rule := range config.Conversions {
+		compiled, err := compileConversionRule(rule)
+		if err != nil {
+			return fmt.Errorf("invalid conversion %s: %w", rule.Name, err)
+		}
+
+		h.conversionRules = append(h.conversionRules, compiled)
+		window = max(window, compiled.Window)
+	}
+	if len(h.conversionRules) > 0 {
+		if config.ConversionsMaxVisitors <= 0 {
+			config.ConversionsMaxVisitors = 10000
+		}
+		h.visitors = newTTLCache(config.ConversionsMaxVisitors, max(window, time.Minute))
+
+		// Visitor keys are only kept in memory, so a salt of the process is enough if hashSalt is not set.
+		h.visitorSalt = h.hashSalt
+		if h.visitorSalt == "" {
+			salt, err := randomSalt()
+			if err != nil {
+				return fmt.Errorf("failed to generate visitor salt: %w", err)
+			}
+			h.visitorSalt = salt
+		}
+	}
+
+	for _, property := range config.DataFromResponse {
+		if !slices.Contains(responseDataProperties, property) {
+			return fmt.Errorf("unknown dataFromResponse property %s", property)
+		}
+	}
+
 	if len(config.IgnoreIPs) > 0 {
 		for _, ignoreIP := range config.IgnoreIPs {
 			network, err := netip.ParsePrefix(ignoreIP)
@@ -303,6 +796,27 @@ func (h *UmamiFeeder) verifyConfig(config *Config) error {
 	return nil
 }
 
+// deferSubmission checks if requests must be submitted once the handler has finished, as the response time,
+// size or body are reported. Streamed responses are then reported when they end.
+func (h *UmamiFeeder) deferSubmission() bool {
+	return h.extractTitle || slices.Contains(h.dataFromResponse, "response_time") ||
+		slices.Contains(h.dataFromResponse, "response_bytes")
+}
+
+// isManagedHost checks if websites of the domain are collected by UmamiHost,
+// the only instance the plugin can create and fetch websites from.
+func (h *UmamiFeeder) isManagedHost(domain string) bool {
+	return h.collectHost(domain) == h.umamiHost
+}
+
+// collectHost returns the URL of the Umami instance, which collects events of the domain.
+func (h *UmamiFeeder) collectHost(domain string) string {
+	if host, ok := h.websiteHosts[domain]; ok {
+		return host
+	}
+	return h.umamiHost
+}
+
 func (h *UmamiFeeder) shouldTrackRequest(req *http.Request) bool {
 	if len(h.ignoreHosts) > 0 {
 		for _, disabledHost := range h.ignoreHosts {
@@ -422,6 +936,14 @@ func (h *UmamiFeeder) error(message string) {
 	}
 }
 
+// Arguments are handled in the manner of [fmt.Printf].
+func (h *UmamiFeeder) infof(format string, v ...any) {
+	if h.logHandler != nil {
+		now := time.Now().Format("2006-01-02T15:04:05Z")
+		h.logHandler.Printf("%s INF middlewareName=%s msg=\"%s\"", now, h.name, fmt.Sprintf(format, v...))
+	}
+}
+
 // Arguments are handled in the manner of [fmt.Printf].
 func (h *UmamiFeeder) debugf(format string, v ...any) {
 	if h.logHandler != nil && h.isDebug {
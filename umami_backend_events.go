@@ -0,0 +1,95 @@
+package traefik_umami_feeder
+
+import (
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// backendSignals are events and data set by the backend with response headers.
+type backendSignals struct {
+	skip   bool
+	events []string
+	data   map[string]any
+}
+
+// readBackendSignals parses and removes the response headers with the given prefix, so they don't reach the client:
+// `<prefix>Event` names events to send, `<prefix>Data-<Property>` adds event data and `<prefix>Skip` suppresses tracking.
+func readBackendSignals(header http.Header, prefix string) *backendSignals {
+	prefix = http.CanonicalHeaderKey(prefix)
+	eventHeader := http.CanonicalHeaderKey(prefix + "Event")
+	skipHeader := http.CanonicalHeaderKey(prefix + "Skip")
+	dataPrefix := http.CanonicalHeaderKey(prefix + "Data-")
+
+	var signals *backendSignals
+	for name, values := range header {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		header.Del(name)
+
+		if signals == nil {
+			signals = &backendSignals{}
+		}
+
+		switch {
+		case name == eventHeader:
+			for _, value := range values {
+				for _, event := range strings.Split(value, ",") {
+					if event = strings.TrimSpace(event); event != "" {
+						signals.events = append(signals.events, event)
+					}
+				}
+			}
+		case name == skipHeader:
+			value := strings.TrimSpace(values[0])
+			signals.skip = value != "" && value != "0" && !strings.EqualFold(value, "false")
+		case strings.HasPrefix(name, dataPrefix) && len(name) > len(dataPrefix):
+			if signals.data == nil {
+				signals.data = make(map[string]any)
+			}
+			property := strings.ReplaceAll(strings.ToLower(name[len(dataPrefix):]), "-", "_")
+			signals.data[property] = parseDataValue(values[0])
+		}
+	}
+	return signals
+}
+
+// parseDataValue converts numeric and boolean header values, so Umami can aggregate them.
+func parseDataValue(value string) any {
+	value = strings.TrimSpace(value)
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+		return strings.EqualFold(value, "true")
+	}
+	return value
+}
+
+// applyBackendSignals adds backend data to the pageview, or to the backend events if there are any.
+func (h *UmamiFeeder) applyBackendSignals(signals *backendSignals, pageview *UmamiEvent) []*UmamiEvent {
+	if signals == nil {
+		return nil
+	}
+
+	if len(signals.events) == 0 {
+		for property, value := range signals.data {
+			h.setEventData(pageview, property, value)
+		}
+		return nil
+	}
+
+	events := make([]*UmamiEvent, 0, len(signals.events))
+	for _, name := range signals.events {
+		event := *pageview
+		event.Name = name
+		event.Data = maps.Clone(pageview.Data)
+		for property, value := range signals.data {
+			h.setEventData(&event, property, value)
+		}
+		events = append(events, &event)
+	}
+	return events
+}
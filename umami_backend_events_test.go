@@ -0,0 +1,113 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackendEvents(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/checkout":
+			rw.Header().Set("X-Umami-Event", "purchase")
+			rw.Header().Set("X-Umami-Data-Revenue", "49.90")
+			rw.Header().Set("X-Umami-Data-Order-Id", "A-1")
+		case "/health":
+			rw.Header().Set("X-Umami-Skip", "1")
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.backendEvents = true
+	feeder.backendHeaderPrefix = "X-Umami-"
+
+	recorder := httptest.NewRecorder()
+	feeder.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/checkout", nil))
+	if len(recorder.Header()) != 0 {
+		t.Fatalf("expected backend headers to be removed, got %v", recorder.Header())
+	}
+	if len(feeder.queue) != 2 {
+		t.Fatalf("expected pageview and event, got %d", len(feeder.queue))
+	}
+	if pageview := (<-feeder.queue).Payload; pageview.Name != "" {
+		t.Fatalf("expected pageview first, got %s", pageview.Name)
+	}
+	event := (<-feeder.queue).Payload
+	if event.Name != "purchase" || event.Data["revenue"] != 49.9 || event.Data["order_id"] != "A-1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	if event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/health", nil)); event != nil {
+		t.Fatalf("expected request to be skipped, got %+v", event)
+	}
+
+	feeder.ignoreHosts = []string{"example.com"}
+	recorder = httptest.NewRecorder()
+	feeder.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/health", nil))
+	if recorder.Header().Get("X-Umami-Skip") != "" {
+		t.Fatal("expected backend headers to be removed from untracked requests")
+	}
+}
+
+func TestReadBackendSignals(t *testing.T) {
+	header := http.Header{}
+	header.Add("X-Umami-Event", "signup, newsletter")
+	header.Set("X-Umami-Data-Plan", "pro")
+	header.Set("X-Umami-Data-Trial", "true")
+	header.Set("Content-Type", "text/html")
+
+	signals := readBackendSignals(header, "x-umami-")
+	if len(signals.events) != 2 || signals.events[1] != "newsletter" {
+		t.Fatalf("unexpected events %v", signals.events)
+	}
+	if signals.data["plan"] != "pro" || signals.data["trial"] != true || signals.skip {
+		t.Fatalf("unexpected signals %+v", signals)
+	}
+	if len(header) != 1 {
+		t.Fatalf("expected only Content-Type to remain, got %v", header)
+	}
+	if readBackendSignals(header, "X-Umami-") != nil {
+		t.Fatal("expected no signals without backend headers")
+	}
+}
+
+func TestBackendEventsHashData(t *testing.T) {
+	feeder := &UmamiFeeder{hashData: []string{"user", "plan"}, hashSalt: "salt"}
+	pageview := &UmamiEvent{}
+	feeder.setEventData(pageview, "user", "alice")
+
+	events := feeder.applyBackendSignals(&backendSignals{
+		events: []string{"upgrade"},
+		data:   map[string]any{"plan": "pro"},
+	}, pageview)
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %d", len(events))
+	}
+	if events[0].Data["user"] != pageview.Data["user"] {
+		t.Fatalf("expected pageview data to be copied as is, got %v", events[0].Data["user"])
+	}
+	if events[0].Data["plan"] != hashValue("salt", "pro") {
+		t.Fatalf("expected backend data to be hashed, got %v", events[0].Data["plan"])
+	}
+	if _, ok := pageview.Data["plan"]; ok {
+		t.Fatal("expected pageview data to be unchanged")
+	}
+}
+
+func TestBackendEventsStrippedWhenDisabled(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Umami-Event", "signup")
+	}))
+	feeder.isEnabled = false
+	feeder.backendEvents = true
+	feeder.backendHeaderPrefix = "X-Umami-"
+
+	recorder := httptest.NewRecorder()
+	feeder.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Header().Get("X-Umami-Event") != "" {
+		t.Fatal("expected backend headers to be removed while the plugin is disabled")
+	}
+	if len(feeder.queue) != 0 {
+		t.Fatalf("expected no events while the plugin is disabled, got %d", len(feeder.queue))
+	}
+}
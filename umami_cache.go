@@ -0,0 +1,99 @@
+package traefik_umami_feeder
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCache is a bounded LRU cache, which expires entries after a fixed time to live.
+type ttlCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Front is the most recently used entry
+}
+
+type ttlCacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+func newTTLCache(capacity int, ttl time.Duration) *ttlCache {
+	return &ttlCache{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value of the key, if it exists and is not expired.
+func (c *ttlCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(key)
+}
+
+// Set stores the value and resets its expiration, evicting the least recently used entry if the cache is full.
+func (c *ttlCache) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value)
+}
+
+// Update atomically replaces the value of the key with the result of update, which receives the current value
+// and whether it exists. The value is only stored if update returns true.
+func (c *ttlCache) Update(key string, update func(value any, ok bool) (any, bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if value, store := update(c.get(key)); store {
+		c.set(key, value)
+	}
+}
+
+func (c *ttlCache) get(key string) (any, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*ttlCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *ttlCache) set(key string, value any) {
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*ttlCacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	for c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ttlCacheEntry).key)
+	}
+
+	c.entries[key] = c.order.PushFront(&ttlCacheEntry{key: key, value: value, expires: expires})
+}
+
+// Len returns the number of entries, including expired ones which were not accessed yet.
+func (c *ttlCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,119 @@
+package traefik_umami_feeder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// umamiCapabilities describes the API features supported by an Umami instance.
+type umamiCapabilities struct {
+	Version     string
+	Batch       bool // POST /api/batch accepts multiple events at once
+	Identify    bool // "identify" payloads with session data
+	Tags        bool // "tag" field in event payloads
+	DistinctIds bool // "id" field in event payloads
+}
+
+func (c *umamiCapabilities) String() string {
+	version := c.Version
+	if version == "" {
+		version = "unknown"
+	}
+	return fmt.Sprintf("version=%s batch=%t identify=%t tags=%t distinctIds=%t",
+		version, c.Batch, c.Identify, c.Tags, c.DistinctIds)
+}
+
+type versionResponse struct {
+	Version string `json:"version"`
+}
+
+// probeCapabilities checks which API features are supported by the Umami instance, based on the version it reports.
+// Only read-only endpoints are requested, nothing is written to the instance.
+func probeCapabilities(ctx context.Context, umamiHost string, headers http.Header) (*umamiCapabilities, error) {
+	resp, err := sendRequest(ctx, umamiHost+"/api/heartbeat", nil, headers.Clone())
+	if err != nil {
+		return nil, fmt.Errorf("heartbeat failed: %w", err)
+	}
+	var heartbeat versionResponse
+	_ = json.NewDecoder(resp.Body).Decode(&heartbeat) // The heartbeat response has no version in most releases.
+	_ = resp.Body.Close()
+
+	version := heartbeat.Version
+	// Neither endpoint is available in every version, so the version stays unknown if both fail.
+	for _, endpoint := range []string{"/api/version", "/api/config"} {
+		if version != "" {
+			break
+		}
+
+		var result versionResponse
+		if err := sendRequestAndParse(ctx, umamiHost+endpoint, nil, headers.Clone(), &result); err == nil {
+			version = result.Version
+		}
+	}
+
+	return capabilitiesOf(strings.TrimPrefix(version, "v")), nil
+}
+
+// latestCapabilities returns the features of current Umami releases.
+func latestCapabilities() *umamiCapabilities {
+	return &umamiCapabilities{Batch: true, Identify: true, Tags: true, DistinctIds: true}
+}
+
+// capabilitiesOf returns the features supported by the given Umami version.
+// If the version is unknown, the instance is assumed to be a current release.
+func capabilitiesOf(version string) *umamiCapabilities {
+	if version == "" {
+		return latestCapabilities()
+	}
+
+	return &umamiCapabilities{
+		Version:     version,
+		Identify:    versionAtLeast(version, "2.3.0"),
+		Tags:        versionAtLeast(version, "2.17.0"),
+		DistinctIds: versionAtLeast(version, "2.17.0"),
+		Batch:       versionAtLeast(version, "2.18.0"),
+	}
+}
+
+// versionAtLeast compares dot-separated numeric versions, pre-release suffixes are ignored.
+func versionAtLeast(version, minimum string) bool {
+	current := strings.Split(version, ".")
+	required := strings.Split(minimum, ".")
+	for i, part := range required {
+		want, _ := strconv.Atoi(part)
+		got := 0
+		if i < len(current) {
+			got, _ = strconv.Atoi(strings.SplitN(current[i], "-", 2)[0])
+		}
+		if got != want {
+			return got > want
+		}
+	}
+	return true
+}
+
+// capabilitiesFor returns the capabilities of the Umami instance, which collects events for the given host.
+// If the instance was not probed, it is treated like an instance with unknown version.
+func (h *UmamiFeeder) capabilitiesFor(umamiHost string) *umamiCapabilities {
+	if capabilities, ok := h.capabilities[umamiHost]; ok {
+		return capabilities
+	}
+	return latestCapabilities()
+}
+
+// disableBatch records that umamiHost has no batch API, so following events are sent one by one.
+func (h *UmamiFeeder) disableBatch(umamiHost string) {
+	capabilities := *h.capabilitiesFor(umamiHost)
+	capabilities.Batch = false
+	h.capabilities[umamiHost] = &capabilities
+}
+
+// isBatchUnsupported reports whether the batch request failed, because the Umami instance has no batch API.
+func isBatchUnsupported(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "status 404") || strings.Contains(message, "status 405")
+}
@@ -0,0 +1,152 @@
+package traefik_umami_feeder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version, minimum string
+		expected         bool
+	}{
+		{"2.17.0", "2.17.0", true},
+		{"2.18.1", "2.17.0", true},
+		{"3.0.0-beta.1", "2.17.0", true},
+		{"2.9", "2.17.0", false},
+		{"1.40.0", "2.0.0", false},
+	}
+	for _, c := range cases {
+		if versionAtLeast(c.version, c.minimum) != c.expected {
+			t.Fatalf("expected %v for %s >= %s", c.expected, c.version, c.minimum)
+		}
+	}
+}
+
+func TestProbeCapabilitiesVersions(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected umamiCapabilities
+	}{
+		{"", umamiCapabilities{Batch: true, Identify: true, Tags: true, DistinctIds: true}},
+		{"1.40.0", umamiCapabilities{Version: "1.40.0"}},
+		{"v2.2.0", umamiCapabilities{Version: "2.2.0"}},
+		{"2.3.0", umamiCapabilities{Version: "2.3.0", Identify: true}},
+		{"2.17.0", umamiCapabilities{Version: "2.17.0", Identify: true, Tags: true, DistinctIds: true}},
+		{"2.18.0", umamiCapabilities{Version: "2.18.0", Batch: true, Identify: true, Tags: true, DistinctIds: true}},
+	}
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodGet {
+				t.Errorf("unexpected %s %s while probing", req.Method, req.URL.Path)
+			}
+			switch req.URL.Path {
+			case "/api/heartbeat":
+				_, _ = rw.Write([]byte(`{"ok":true}`))
+			case "/api/version":
+				_, _ = rw.Write([]byte(`{"version":"` + c.version + `"}`))
+			default:
+				rw.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		capabilities, err := probeCapabilities(context.Background(), server.URL, nil)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *capabilities != c.expected {
+			t.Errorf("expected %s for version %q, got %s", &c.expected, c.version, capabilities)
+		}
+	}
+}
+
+func TestProbeCapabilitiesWithoutBatch(t *testing.T) {
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/heartbeat":
+			_, _ = rw.Write([]byte(`{"ok":true}`))
+		case "/api/config":
+			_, _ = rw.Write([]byte(`{"version":"2.9.0"}`))
+		case "/api/send":
+			sent = append(sent, req.Header.Get("User-Agent"))
+			_, _ = rw.Write([]byte(`{}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	capabilities, err := probeCapabilities(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capabilities.Version != "2.9.0" || capabilities.Batch || capabilities.Tags || !capabilities.Identify {
+		t.Fatalf("unexpected capabilities %s", capabilities)
+	}
+
+	feeder := &UmamiFeeder{umamiHost: server.URL, capabilities: map[string]*umamiCapabilities{server.URL: capabilities}}
+	feeder.reportEventsToUmami(context.Background(), []*SendBody{
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.com", UserAgent: "first"}},
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.com", UserAgent: "second"}},
+	})
+	if len(sent) != 2 || sent[0] != "first" || sent[1] != "second" {
+		t.Fatalf("expected events to be sent one by one, got %v", sent)
+	}
+}
+
+func TestBatchFallback(t *testing.T) {
+	var batches, sent int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/batch":
+			batches++
+			rw.WriteHeader(http.StatusNotFound)
+		case "/api/send":
+			sent++
+			_, _ = rw.Write([]byte(`{}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	feeder := &UmamiFeeder{umamiHost: server.URL, capabilities: map[string]*umamiCapabilities{}}
+	events := func() []*SendBody {
+		return []*SendBody{
+			{Type: "event", Payload: &UmamiEvent{Hostname: "example.com", Tag: "prod"}},
+			{Type: "event", Payload: &UmamiEvent{Hostname: "example.com", Tag: "prod"}},
+		}
+	}
+	feeder.reportEventsToUmami(context.Background(), events())
+	feeder.reportEventsToUmami(context.Background(), events())
+
+	if batches != 1 || sent != 4 {
+		t.Fatalf("expected one failed batch and then events one by one, got %d batches and %d events", batches, sent)
+	}
+	if capabilities := feeder.capabilitiesFor(server.URL); capabilities.Batch || !capabilities.Tags {
+		t.Fatalf("expected only batch to be disabled, got %s", capabilities)
+	}
+}
+
+func TestPreparePayloads(t *testing.T) {
+	events := []*SendBody{{Type: "event", Payload: &UmamiEvent{Id: "visitor", Tag: "prod"}}}
+
+	events = preparePayloads(events, &umamiCapabilities{DistinctIds: true, Tags: true})
+	if events[0].Payload.Id != "visitor" || events[0].Payload.Tag != "prod" {
+		t.Fatal("expected id and tag to be kept")
+	}
+
+	events = preparePayloads(events, &umamiCapabilities{})
+	if events[0].Payload.Id != "" || events[0].Payload.Tag != "" {
+		t.Fatal("expected id and tag to be removed")
+	}
+
+	events = preparePayloads([]*SendBody{{Type: "identify", Payload: &UmamiEvent{}}}, &umamiCapabilities{})
+	if len(events) != 0 {
+		t.Fatal("expected identify to be removed")
+	}
+}
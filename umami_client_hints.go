@@ -0,0 +1,78 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// clientHintsHeaders are requested from browsers with Accept-CH header on HTML responses.
+const clientHintsHeaders = "Sec-CH-Viewport-Width, Sec-CH-Viewport-Height, Sec-CH-UA-Mobile, " +
+	"Sec-CH-UA-Platform, Sec-CH-UA-Platform-Version, Sec-CH-UA-Model, Sec-CH-UA-Full-Version-List"
+
+// parseScreen returns the viewport size in CSS pixels (ex. "1920x1080") from client hints, or an empty string.
+// Device pixel ratio is not applied, as Umami expects CSS pixels the same way the tracker reports `screen.width`.
+func parseScreen(req *http.Request) string {
+	width, err := strconv.ParseFloat(req.Header.Get("Sec-CH-Viewport-Width"), 64)
+	if err != nil || width <= 0 {
+		return ""
+	}
+
+	height, err := strconv.ParseFloat(req.Header.Get("Sec-CH-Viewport-Height"), 64)
+	if err != nil || height <= 0 {
+		return ""
+	}
+
+	return strconv.Itoa(int(width)) + "x" + strconv.Itoa(int(height))
+}
+
+var (
+	brandVersionRegexp   = regexp.MustCompile(`"([^"]+)";\s*v="([^"]+)"`)
+	reducedChromeRegexp  = regexp.MustCompile(`Chrome/\d+\.0\.0\.0`)
+	reducedEdgeRegexp    = regexp.MustCompile(`Edg/\d+\.0\.0\.0`)
+	reducedAndroidRegexp = regexp.MustCompile(`Android 10; K\)`)
+)
+
+// parseBrandVersions parses a structured header list like `"Chromium";v="131.0.6778.85", "Not_A Brand";v="24"`.
+func parseBrandVersions(header string) map[string]string {
+	versions := make(map[string]string)
+	for _, match := range brandVersionRegexp.FindAllStringSubmatch(header, -1) {
+		versions[match[1]] = match[2]
+	}
+	return versions
+}
+
+// clientHintsUserAgent restores details removed from the reduced User-Agent string of Chromium browsers
+// (full browser version, Android version and device model, mobile flag) from the client hints.
+func clientHintsUserAgent(req *http.Request) string {
+	userAgent := req.UserAgent()
+	if userAgent == "" {
+		return userAgent
+	}
+
+	versions := parseBrandVersions(req.Header.Get("Sec-CH-UA-Full-Version-List"))
+	if version, ok := versions["Chromium"]; ok {
+		userAgent = reducedChromeRegexp.ReplaceAllLiteralString(userAgent, "Chrome/"+version)
+	}
+	if version, ok := versions["Microsoft Edge"]; ok {
+		userAgent = reducedEdgeRegexp.ReplaceAllLiteralString(userAgent, "Edg/"+version)
+	}
+
+	platform := strings.Trim(req.Header.Get("Sec-CH-UA-Platform"), `"`)
+	platformVersion := strings.Trim(req.Header.Get("Sec-CH-UA-Platform-Version"), `"`)
+	model := strings.Trim(req.Header.Get("Sec-CH-UA-Model"), `"`)
+	if platform == "Android" && platformVersion != "" {
+		replacement := "Android " + platformVersion
+		if model != "" {
+			replacement += "; " + model
+		}
+		userAgent = reducedAndroidRegexp.ReplaceAllLiteralString(userAgent, replacement+")")
+	}
+
+	if req.Header.Get("Sec-CH-UA-Mobile") == "?1" && !strings.Contains(userAgent, "Mobile") {
+		userAgent = strings.Replace(userAgent, " Safari/", " Mobile Safari/", 1)
+	}
+
+	return userAgent
+}
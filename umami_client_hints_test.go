@@ -0,0 +1,78 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientHints(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("<html><head><title>Hi</title></head></html>"))
+	}))
+	feeder.clientHints = true
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36")
+	req.Header.Set("Sec-CH-Viewport-Width", "412")
+	req.Header.Set("Sec-CH-Viewport-Height", "915")
+	req.Header.Set("Sec-CH-UA-Mobile", "?1")
+	req.Header.Set("Sec-CH-UA-Platform", `"Android"`)
+	req.Header.Set("Sec-CH-UA-Platform-Version", `"14.0.0"`)
+	req.Header.Set("Sec-CH-UA-Model", `"Pixel 8"`)
+	req.Header.Set("Sec-CH-UA-Full-Version-List", `"Google Chrome";v="131.0.6778.85", "Chromium";v="131.0.6778.85", "Not_A Brand";v="24.0.0.0"`)
+	feeder.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get("Accept-CH") != clientHintsHeaders {
+		t.Fatalf("expected Accept-CH header, got %v", recorder.Header())
+	}
+
+	event := (<-feeder.queue).Payload
+	if event.Screen != "412x915" {
+		t.Fatalf("unexpected screen %s", event.Screen)
+	}
+
+	expected := "Mozilla/5.0 (Linux; Android 14.0.0; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.6778.85 Mobile Safari/537.36"
+	if event.UserAgent != expected {
+		t.Fatalf("unexpected user agent %s", event.UserAgent)
+	}
+}
+
+func TestClientHintsNotRequestedForOtherContent(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte("{}"))
+	}))
+	feeder.clientHints = true
+
+	recorder := httptest.NewRecorder()
+	feeder.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	if recorder.Header().Get("Accept-CH") != "" {
+		t.Fatalf("unexpected Accept-CH header %s", recorder.Header().Get("Accept-CH"))
+	}
+	if event := (<-feeder.queue).Payload; event.Screen != "" {
+		t.Fatalf("unexpected screen %s", event.Screen)
+	}
+}
+
+func TestParseScreen(t *testing.T) {
+	cases := []struct {
+		width, height, expected string
+	}{
+		{"1920", "1080", "1920x1080"},
+		{"412.5", "915", "412x915"},
+		{"1920", "", ""},
+		{"1920", "0", ""},
+		{"", "1080", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Sec-CH-Viewport-Width", c.width)
+		req.Header.Set("Sec-CH-Viewport-Height", c.height)
+		if screen := parseScreen(req); screen != c.expected {
+			t.Errorf("expected %q for %sx%s, got %q", c.expected, c.width, c.height, screen)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package traefik_umami_feeder
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// ConversionRule defines a conversion event, which is sent when a visitor reaches the goal URL.
+type ConversionRule struct {
+	// Name of the event.
+	Name string `json:"name"`
+	// URL is a regular expression of the goal path, e.g. `^/order/complete$`.
+	URL string `json:"url"`
+	// Require is an optional regular expression of a path, which the visitor must have visited before the goal.
+	Require string `json:"require"`
+	// Window is the maximum time between the required step and the goal, defaults to 30 minutes.
+	Window time.Duration `json:"window"`
+}
+
+type conversionRule struct {
+	ConversionRule
+	url     *regexp.Regexp
+	require *regexp.Regexp
+}
+
+func compileConversionRule(rule ConversionRule) (*conversionRule, error) {
+	if rule.Name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	url, err := regexp.Compile(rule.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile url %s: %w", rule.URL, err)
+	}
+
+	compiled := &conversionRule{ConversionRule: rule, url: url}
+	if rule.Require != "" {
+		compiled.require, err = regexp.Compile(rule.Require)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile require %s: %w", rule.Require, err)
+		}
+		if compiled.Window <= 0 {
+			compiled.Window = 30 * time.Minute
+		}
+	}
+	return compiled, nil
+}
+
+// visitorKey identifies a visitor without storing the IP address or user agent.
+func (h *UmamiFeeder) visitorKey(event *UmamiEvent) string {
+	return hashValue(h.visitorSalt, event.Website+"\n"+event.Ip+"\n"+event.UserAgent)
+}
+
+// applyConversions records the visited steps and returns conversion events for the goals reached by the request.
+func (h *UmamiFeeder) applyConversions(req *http.Request, statusCode int, pageview *UmamiEvent) []*UmamiEvent {
+	if len(h.conversionRules) == 0 || statusCode >= 400 {
+		return nil
+	}
+
+	var events []*UmamiEvent
+	// The steps are read and replaced under the cache lock, so concurrent requests can't both count a conversion.
+	h.visitors.Update(h.visitorKey(pageview), func(value any, ok bool) (any, bool) {
+		var steps map[int]time.Time
+		if ok {
+			steps = value.(map[int]time.Time)
+		}
+
+		now := time.Now()
+		updated := maps.Clone(steps)
+		for i, rule := range h.conversionRules {
+			if rule.url.MatchString(req.URL.Path) {
+				if rule.require != nil {
+					visited, ok := updated[i]
+					if !ok || now.Sub(visited) > rule.Window {
+						continue
+					}
+					delete(updated, i) // Count the conversion once per visited step.
+				}
+
+				event := *pageview
+				event.Name = rule.Name
+				event.Data = maps.Clone(pageview.Data)
+				h.debugf("conversion '%s' reached at %s", rule.Name, req.URL.Path)
+				events = append(events, &event)
+				continue
+			}
+
+			if rule.require != nil && rule.require.MatchString(req.URL.Path) {
+				if updated == nil {
+					updated = make(map[int]time.Time)
+				}
+				updated[i] = now
+			}
+		}
+
+		return updated, !maps.Equal(steps, updated)
+	})
+	return events
+}
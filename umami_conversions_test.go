@@ -0,0 +1,133 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConversions(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	err := feeder.verifyConfig(&Config{Conversions: []ConversionRule{
+		{Name: "purchase", URL: "^/order/complete$", Require: "^/cart", Window: time.Hour},
+		{Name: "contact", URL: "^/contact/thanks$"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visit := func(ip, target string) []string {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		events := feeder.applyConversions(req, http.StatusOK, &UmamiEvent{Website: "website", Ip: ip, UserAgent: "ua", Url: target})
+
+		names := make([]string, 0, len(events))
+		for _, event := range events {
+			names = append(names, event.Name)
+		}
+		return names
+	}
+
+	if names := visit("1.1.1.1", "/order/complete"); len(names) != 0 {
+		t.Fatalf("expected no conversion without required step, got %v", names)
+	}
+	if names := visit("1.1.1.1", "/cart"); len(names) != 0 {
+		t.Fatalf("expected no conversion on required step, got %v", names)
+	}
+	if names := visit("2.2.2.2", "/order/complete"); len(names) != 0 {
+		t.Fatalf("expected no conversion for another visitor, got %v", names)
+	}
+	if names := visit("1.1.1.1", "/order/complete"); len(names) != 1 || names[0] != "purchase" {
+		t.Fatalf("expected purchase conversion, got %v", names)
+	}
+	if names := visit("1.1.1.1", "/order/complete"); len(names) != 0 {
+		t.Fatalf("expected conversion to be counted once, got %v", names)
+	}
+	if names := visit("3.3.3.3", "/contact/thanks"); len(names) != 1 || names[0] != "contact" {
+		t.Fatalf("expected contact conversion, got %v", names)
+	}
+}
+
+func TestConversionsWindow(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	err := feeder.verifyConfig(&Config{Conversions: []ConversionRule{
+		{Name: "purchase", URL: "^/order/complete$", Require: "^/cart", Window: time.Millisecond},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageview := &UmamiEvent{Website: "website", Ip: "1.1.1.1", UserAgent: "ua"}
+	feeder.applyConversions(httptest.NewRequest(http.MethodGet, "/cart", nil), http.StatusOK, pageview)
+	time.Sleep(5 * time.Millisecond)
+
+	events := feeder.applyConversions(httptest.NewRequest(http.MethodGet, "/order/complete", nil), http.StatusOK, pageview)
+	if len(events) != 0 {
+		t.Fatal("expected no conversion after the window")
+	}
+}
+
+func TestConversionsConcurrent(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	err := feeder.verifyConfig(&Config{Conversions: []ConversionRule{
+		{Name: "purchase", URL: "^/order/complete$", Require: "^/cart", Window: time.Hour},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageview := &UmamiEvent{Website: "website", Ip: "1.1.1.1", UserAgent: "ua"}
+	feeder.applyConversions(httptest.NewRequest(http.MethodGet, "/cart", nil), http.StatusOK, pageview)
+
+	var wg sync.WaitGroup
+	var conversions atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/order/complete", nil)
+			conversions.Add(int32(len(feeder.applyConversions(req, http.StatusOK, pageview))))
+		}()
+	}
+	wg.Wait()
+
+	if conversions.Load() != 1 {
+		t.Fatalf("expected conversion to be counted once, got %d", conversions.Load())
+	}
+}
+
+func TestConversionOfRedirect(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Location", "/thanks")
+		rw.WriteHeader(http.StatusFound)
+	}))
+	feeder.trackRedirects = trackRedirectsEvent
+	err := feeder.verifyConfig(&Config{Conversions: []ConversionRule{{Name: "signup", URL: "^/signup/done$"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/signup/done", nil))
+	if len(feeder.queue) != 2 {
+		t.Fatalf("expected redirect and conversion, got %d", len(feeder.queue))
+	}
+	<-feeder.queue
+	conversion := (<-feeder.queue).Payload
+	if conversion.Name != "signup" || conversion.Data != nil {
+		t.Fatalf("expected conversion without redirect data, got %+v", conversion)
+	}
+}
+
+func TestVisitorKeyIsSalted(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	if err := feeder.verifyConfig(&Config{Conversions: []ConversionRule{{Name: "signup", URL: "^/signup$"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	visitor := &UmamiEvent{Website: "website", Ip: "1.1.1.1", UserAgent: "ua"}
+	if feeder.visitorKey(visitor) == hashValue("", "website\n1.1.1.1\nua") {
+		t.Fatal("expected visitor key to be salted without hashSalt")
+	}
+}
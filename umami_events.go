@@ -0,0 +1,130 @@
+package traefik_umami_feeder
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EventRule defines a custom event, which is sent for requests matching the rule.
+type EventRule struct {
+	// Name of the event, may reference capture groups of URL, e.g. `$1` or `${name}`.
+	Name string `json:"name"`
+	// URL is a regular expression matched against the request path.
+	URL string `json:"url"`
+	// Methods limits the rule to the given HTTP methods, if empty all methods match.
+	Methods []string `json:"methods"`
+	// Status limits the rule to the given status codes, `x` can be used as a wildcard digit (e.g. `2xx`).
+	Status []string `json:"status"`
+	// Pageview when set to true, the pageview is sent in addition to the event.
+	Pageview bool `json:"pageview"`
+	// Data is a map of event data, values may reference capture groups of URL.
+	Data map[string]string `json:"data"`
+}
+
+type eventRule struct {
+	EventRule
+	url *regexp.Regexp
+}
+
+func compileEventRule(rule EventRule) (*eventRule, error) {
+	if rule.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(rule.Name) > maxEventNameLength {
+		return nil, fmt.Errorf("name must not exceed %d characters", maxEventNameLength)
+	}
+
+	r, err := regexp.Compile(rule.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile url %s: %w", rule.URL, err)
+	}
+
+	for _, status := range rule.Status {
+		if len(status) != 3 || strings.Trim(strings.ToLower(status), "0123456789x") != "" {
+			return nil, fmt.Errorf("invalid status %s", status)
+		}
+	}
+
+	return &eventRule{EventRule: rule, url: r}, nil
+}
+
+func (r *eventRule) matchStatus(statusCode int) bool {
+	if len(r.Status) == 0 {
+		return true
+	}
+
+	code := strconv.Itoa(statusCode)
+	return slices.ContainsFunc(r.Status, func(pattern string) bool {
+		if len(code) != len(pattern) {
+			return false
+		}
+		for i := 0; i < len(pattern); i++ {
+			if pattern[i] != 'x' && pattern[i] != 'X' && pattern[i] != code[i] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// match returns the regexp submatch indexes of the request path, or nil if the rule doesn't match the request.
+func (r *eventRule) match(req *http.Request, statusCode int) []int {
+	if len(r.Methods) > 0 && !slices.ContainsFunc(r.Methods, func(method string) bool {
+		return strings.EqualFold(method, req.Method)
+	}) {
+		return nil
+	}
+
+	if !r.matchStatus(statusCode) {
+		return nil
+	}
+
+	return r.url.FindStringSubmatchIndex(req.URL.Path)
+}
+
+// matchEventRules checks if any event rule matches the request, so that errors are reported even if TrackErrors
+// is disabled.
+func (h *UmamiFeeder) matchEventRules(req *http.Request, statusCode int) bool {
+	return slices.ContainsFunc(h.eventRules, func(rule *eventRule) bool {
+		return rule.match(req, statusCode) != nil
+	})
+}
+
+// applyEventRules returns the events to send for the request: custom events of every matching rule,
+// preceded by the pageview unless a rule matched which doesn't keep it, or the request is an untracked error.
+func (h *UmamiFeeder) applyEventRules(req *http.Request, statusCode int, pageview *UmamiEvent) []*UmamiEvent {
+	if len(h.eventRules) == 0 {
+		return []*UmamiEvent{pageview}
+	}
+
+	var events []*UmamiEvent
+	keepPageview := statusCode < 400 || h.trackErrors
+	for _, rule := range h.eventRules {
+		match := rule.match(req, statusCode)
+		if match == nil {
+			continue
+		}
+		keepPageview = keepPageview && rule.Pageview
+
+		event := *pageview
+		event.Name = string(rule.url.ExpandString(nil, rule.Name, req.URL.Path, match))
+		event.Data = maps.Clone(pageview.Data)
+		for key, template := range rule.Data {
+			h.setEventData(&event, key, string(rule.url.ExpandString(nil, template, req.URL.Path, match)))
+		}
+
+		h.debugf("event '%s' matched for %s", event.Name, req.URL.Path)
+		events = append(events, &event)
+	}
+
+	if keepPageview {
+		events = append([]*UmamiEvent{pageview}, events...)
+	}
+	return events
+}
@@ -0,0 +1,88 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEventRules(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	err := feeder.verifyConfig(&Config{Events: []EventRule{
+		{Name: "signup", URL: "^/api/signup$", Methods: []string{"POST"}, Status: []string{"2xx"}},
+		{Name: "pricing_view", URL: "^/pricing", Pageview: true},
+		{Name: "${section}_view", URL: `^/docs/(?P<section>\w+)/(\d+)`, Pageview: true, Data: map[string]string{"page": "$2"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method string
+		url    string
+		status int
+		names  []string
+	}{
+		{http.MethodPost, "/api/signup", http.StatusCreated, []string{"signup"}},
+		{http.MethodPost, "/api/signup", http.StatusBadRequest, []string{}},
+		{http.MethodGet, "/api/signup", http.StatusOK, []string{""}},
+		{http.MethodGet, "/pricing", http.StatusOK, []string{"", "pricing_view"}},
+		{http.MethodGet, "/docs/guide/42", http.StatusOK, []string{"", "guide_view"}},
+		{http.MethodGet, "/about", http.StatusOK, []string{""}},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "http://example.com"+c.url, nil)
+		events := feeder.applyEventRules(req, c.status, &UmamiEvent{Url: c.url})
+
+		names := make([]string, 0, len(events))
+		for _, event := range events {
+			names = append(names, event.Name)
+		}
+		if len(names) != len(c.names) {
+			t.Fatalf("expected events %v for %s %s, got %v", c.names, c.method, c.url, names)
+		}
+		for i := range names {
+			if names[i] != c.names[i] {
+				t.Fatalf("expected events %v for %s %s, got %v", c.names, c.method, c.url, names)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/docs/guide/42", nil)
+	events := feeder.applyEventRules(req, http.StatusOK, &UmamiEvent{})
+	if events[1].Data["page"] != "42" || events[0].Data != nil {
+		t.Fatalf("unexpected data %v, %v", events[0].Data, events[1].Data)
+	}
+}
+
+func TestEventRulesWithoutTrackErrors(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	err := feeder.verifyConfig(&Config{Events: []EventRule{{Name: "not_found", URL: "^/", Status: []string{"404"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/missing", nil))
+	if event == nil || event.Name != "not_found" {
+		t.Fatalf("expected not_found event, got %+v", event)
+	}
+	if len(feeder.queue) != 0 {
+		t.Fatalf("expected no pageview of the error, got %d more events", len(feeder.queue))
+	}
+}
+
+func TestInvalidEventRule(t *testing.T) {
+	invalid := []EventRule{
+		{URL: "^/"},
+		{Name: "event", URL: "("},
+		{Name: "event", URL: "^/", Status: []string{"20"}},
+		{Name: "event", URL: "^/", Status: []string{"2y0"}},
+	}
+	for _, rule := range invalid {
+		if _, err := compileEventRule(rule); err == nil {
+			t.Fatalf("expected error for %v", rule)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package traefik_umami_feeder
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// SessionDataConfig defines session properties, which are sent once per visitor session with an identify call.
+type SessionDataConfig struct {
+	// Headers is a map of request header name to session property name.
+	Headers map[string]string `json:"headers"`
+	// Cookies is a map of request cookie name to session property name.
+	Cookies map[string]string `json:"cookies"`
+	// Claims is a map of JWT claim name to session property name, requires Jwt to be configured.
+	Claims map[string]string `json:"claims"`
+	// Ttl defines how long the identify call is not repeated for the same visitor and properties.
+	Ttl time.Duration `json:"ttl"`
+	// MaxSessions is the maximum number of sessions remembered to avoid repeated identify calls.
+	MaxSessions int `json:"maxSessions"`
+}
+
+// identifyEvent returns an identify payload with session data of the visitor,
+// or nil if there is no data or it was already sent within the TTL.
+func (h *UmamiFeeder) identifyEvent(req *http.Request, claims map[string]any, pageview *UmamiEvent) *UmamiEvent {
+	if h.sessionData == nil {
+		return nil
+	}
+
+	identify := &UmamiEvent{
+		Website:   pageview.Website,
+		Hostname:  pageview.Hostname,
+		Language:  pageview.Language,
+		Url:       pageview.Url,
+		Ip:        pageview.Ip,
+		UserAgent: pageview.UserAgent,
+		Timestamp: pageview.Timestamp,
+		Screen:    pageview.Screen,
+		Id:        pageview.Id,
+	}
+
+	h.setRequestData(identify, req, h.sessionData.Headers, h.sessionData.Cookies)
+	if claims != nil {
+		for claim, property := range h.sessionData.Claims {
+			if value := claimValue(claims[claim]); value != nil {
+				h.setEventData(identify, property, value)
+			}
+		}
+	}
+
+	if len(identify.Data) == 0 {
+		return nil
+	}
+
+	// Umami identifies sessions by the distinct ID, or by the website, IP and user agent of the visitor.
+	data, _ := json.Marshal(identify.Data)
+	session := identify.Id
+	if session == "" {
+		session = identify.Ip + "\n" + identify.UserAgent
+	}
+	key := hashValue(identify.Website+"\n"+session+"\n", string(data))
+
+	// The check and the update are atomic, so concurrent requests of the same session identify it only once.
+	identified := true
+	h.identified.Update(key, func(_ any, ok bool) (any, bool) {
+		identified = ok
+		return true, !ok
+	})
+	if identified {
+		return nil
+	}
+	return identify
+}
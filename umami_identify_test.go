@@ -0,0 +1,97 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdentifyOncePerSession(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.sessionData = &SessionDataConfig{Headers: map[string]string{"X-Tenant": "tenant"}, Cookies: map[string]string{"locale": "locale"}}
+	if err := feeder.verifyConfig(&Config{SessionData: feeder.sessionData}); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(tenant string) []*SendBody {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		req.AddCookie(&http.Cookie{Name: "locale", Value: "de"})
+		feeder.ServeHTTP(httptest.NewRecorder(), req)
+
+		var bodies []*SendBody
+		for len(feeder.queue) > 0 {
+			bodies = append(bodies, <-feeder.queue)
+		}
+		return bodies
+	}
+
+	bodies := send("acme")
+	if len(bodies) != 2 || bodies[0].Type != "identify" || bodies[1].Type != "event" {
+		t.Fatalf("expected identify and pageview, got %v", bodies)
+	}
+	if bodies[0].Payload.Data["tenant"] != "acme" || bodies[0].Payload.Data["locale"] != "de" || bodies[1].Payload.Data != nil {
+		t.Fatalf("unexpected data %v, %v", bodies[0].Payload.Data, bodies[1].Payload.Data)
+	}
+
+	if bodies = send("acme"); len(bodies) != 1 {
+		t.Fatalf("expected only pageview for the same session, got %d", len(bodies))
+	}
+	if bodies = send("globex"); len(bodies) != 2 {
+		t.Fatalf("expected identify after session data changed, got %d", len(bodies))
+	}
+}
+
+func TestIdentifyConcurrent(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	feeder.sessionData = &SessionDataConfig{Headers: map[string]string{"X-Tenant": "tenant"}}
+	if err := feeder.verifyConfig(&Config{SessionData: feeder.sessionData}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var identified atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Tenant", "acme")
+			if feeder.identifyEvent(req, nil, &UmamiEvent{Website: "website", Ip: "1.1.1.1", UserAgent: "ua"}) != nil {
+				identified.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if identified.Load() != 1 {
+		t.Fatalf("expected session to be identified once, got %d", identified.Load())
+	}
+}
+
+func TestTTLCache(t *testing.T) {
+	cache := newTTLCache(2, time.Hour)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Set("c", 3)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	if value, ok := cache.Get("a"); !ok || value != 1 {
+		t.Fatal("expected entry to be kept")
+	}
+
+	expiring := newTTLCache(2, time.Nanosecond)
+	expiring.Set("a", 1)
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.Get("a"); ok {
+		t.Fatal("expected entry to expire")
+	}
+}
@@ -0,0 +1,290 @@
+package traefik_umami_feeder
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JwtConfig defines how visitor data is extracted from JSON Web Tokens.
+type JwtConfig struct {
+	// Header holding the token, `Bearer` prefix is removed if present, e.g. `Authorization`.
+	Header string `json:"header"`
+	// Cookie holding the token, used if Header is not present.
+	Cookie string `json:"cookie"`
+	// Secret verifies HMAC (HS256, HS384, HS512) signatures, if neither Secret nor JwksFile is set,
+	// tokens are decoded without verification.
+	Secret string `json:"secret"`
+	// JwksFile is a path to a JSON Web Key Set used to verify RSA (RS*, PS*) and ECDSA (ES*) signatures.
+	JwksFile string `json:"jwksFile"`
+	// DistinctIdClaim is a claim used as distinct ID of the visitor, defaults to `sub`.
+	DistinctIdClaim string `json:"distinctIdClaim"`
+	// Claims is a map of claim name to event data property name.
+	Claims map[string]string `json:"claims"`
+}
+
+type jwtKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	publicKey crypto.PublicKey
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// loadJwks reads a JSON Web Key Set from file, keys of unsupported types are skipped.
+func loadJwks(file string) ([]*jwtKey, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []*jwtKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*jwtKey, 0, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		if err := key.parse(); err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", key.Kid, err)
+		}
+		if key.publicKey != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no supported keys found")
+	}
+	return keys, nil
+}
+
+func (k *jwtKey) parse() error {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return err
+		}
+		k.publicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return err
+		}
+		k.publicKey = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	}
+	return nil
+}
+
+// jwtToken returns the raw token of the request, or an empty string.
+func (h *UmamiFeeder) jwtToken(req *http.Request) string {
+	if h.jwt.Header != "" {
+		if value := req.Header.Get(h.jwt.Header); value != "" {
+			if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+				value = value[7:]
+			}
+			return strings.TrimSpace(value)
+		}
+	}
+	if h.jwt.Cookie != "" {
+		if cookie, err := req.Cookie(h.jwt.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// jwtClaims decodes the token of the request and returns its claims, or nil if there is no valid token.
+func (h *UmamiFeeder) jwtClaims(req *http.Request) map[string]any {
+	if h.jwt == nil {
+		return nil
+	}
+
+	token := h.jwtToken(req)
+	if token == "" {
+		return nil
+	}
+
+	claims, err := h.parseJwt(token)
+	if err != nil {
+		h.debugf("invalid token: %v", err)
+		return nil
+	}
+	return claims
+}
+
+func (h *UmamiFeeder) parseJwt(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+
+	if h.jwt.Secret != "" || len(h.jwtKeys) > 0 {
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("malformed signature: %w", err)
+		}
+		if err := h.verifyJwtSignature(header, parts[0]+"."+parts[1], signature); err != nil {
+			return nil, err
+		}
+	}
+
+	payloadJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed payload: %w", err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payloadJson, &claims); err != nil {
+		return nil, fmt.Errorf("malformed payload: %w", err)
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
+		return nil, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+func jwtHash(alg string) (crypto.Hash, func() hash.Hash) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, sha256.New
+	case "384":
+		return crypto.SHA384, sha512.New384
+	case "512":
+		return crypto.SHA512, sha512.New
+	}
+	return 0, nil
+}
+
+func (h *UmamiFeeder) verifyJwtSignature(header jwtHeader, signed string, signature []byte) error {
+	if len(header.Alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %s", header.Alg)
+	}
+	hashType, newHash := jwtHash(header.Alg)
+	if newHash == nil {
+		return fmt.Errorf("unsupported algorithm %s", header.Alg)
+	}
+
+	if strings.HasPrefix(header.Alg, "HS") {
+		if h.jwt.Secret == "" {
+			return errors.New("no secret to verify HMAC signature")
+		}
+
+		mac := hmac.New(newHash, []byte(h.jwt.Secret))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	digest := newHash()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	for _, key := range h.jwtKeys {
+		if header.Kid != "" && key.Kid != "" && header.Kid != key.Kid {
+			continue
+		}
+		if key.Alg != "" && key.Alg != header.Alg {
+			continue
+		}
+
+		switch publicKey := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			var err error
+			switch header.Alg[:2] {
+			case "RS":
+				err = rsa.VerifyPKCS1v15(publicKey, hashType, sum, signature)
+			case "PS":
+				err = rsa.VerifyPSS(publicKey, hashType, sum, signature, nil)
+			default:
+				continue
+			}
+			if err == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			if header.Alg[:2] != "ES" || len(signature) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(publicKey, sum, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// claimValue converts a claim to a value suitable for event data.
+func claimValue(value any) any {
+	switch v := value.(type) {
+	case string, float64, bool:
+		return v
+	case nil:
+		return nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return string(encoded)
+	}
+}
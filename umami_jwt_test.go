@@ -0,0 +1,124 @@
+package traefik_umami_feeder
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func signJwt(t *testing.T, alg, kid string, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hmacSigner(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func TestJwtClaims(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.hashSalt = "salt"
+	feeder.dataFromHeaders = map[string]string{"Authorization": "token"}
+	feeder.jwt = &JwtConfig{Header: "Authorization", Cookie: "session", Claims: map[string]string{"plan": "plan", "org": "org"}}
+	if err := feeder.verifyConfig(&Config{Jwt: feeder.jwt, HashSalt: "salt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{"sub": "user-1", "plan": "pro", "org": map[string]any{"id": 7}, "exp": time.Now().Add(time.Hour).Unix()}
+	token := signJwt(t, "HS256", "", claims, hmacSigner("other"))
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	event := serveTestRequest(t, feeder, req)
+	if event.Id != hashValue("salt", "user-1")[:distinctIdLength] {
+		t.Fatalf("unexpected id %s", event.Id)
+	}
+	if event.Data["plan"] != "pro" || event.Data["org"] != `{"id":7}` {
+		t.Fatalf("unexpected data %v", event.Data)
+	}
+	if _, ok := event.Data["token"]; ok {
+		t.Fatal("raw token must not be forwarded")
+	}
+
+	// Verification with a secret rejects tokens signed with another one.
+	feeder.jwt.Secret = "secret"
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: token})
+	if event := serveTestRequest(t, feeder, req); event.Id != "" {
+		t.Fatalf("expected token to be rejected, got id %s", event.Id)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: signJwt(t, "HS256", "", claims, hmacSigner("secret"))})
+	if event := serveTestRequest(t, feeder, req); event.Id == "" {
+		t.Fatal("expected token to be accepted")
+	}
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: signJwt(t, "HS256", "", claims, hmacSigner("secret"))})
+	if event := serveTestRequest(t, feeder, req); event.Id != "" {
+		t.Fatal("expected expired token to be rejected")
+	}
+}
+
+func TestJwtJwks(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "key-1",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	feeder := &UmamiFeeder{}
+	feeder.jwt = &JwtConfig{Header: "Authorization", JwksFile: file}
+	if err := feeder.verifyConfig(&Config{Jwt: feeder.jwt, HashSalt: "salt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rsaSigner := func(signed []byte) []byte {
+		sum := sha256.Sum256(signed)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return signature
+	}
+
+	token := signJwt(t, "RS256", "key-1", map[string]any{"sub": "user-1"}, rsaSigner)
+	if claims, err := feeder.parseJwt(token); err != nil || claims["sub"] != "user-1" {
+		t.Fatalf("expected token to be verified: %v", err)
+	}
+
+	token = signJwt(t, "HS256", "key-1", map[string]any{"sub": "user-1"}, hmacSigner(""))
+	if _, err := feeder.parseJwt(token); err == nil {
+		t.Fatal("expected HMAC token to be rejected")
+	}
+}
@@ -0,0 +1,138 @@
+package traefik_umami_feeder
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of Umami columns, longer values are rejected or cut by Umami.
+const (
+	maxUrlLength       = 500
+	maxHostnameLength  = 100
+	maxTitleLength     = 500
+	maxEventNameLength = 50
+	maxLanguageLength  = 35
+	maxScreenLength    = 11
+	maxDataKeyLength   = 500
+	maxDataDepth       = 3
+	maxNumberPrecision = 4
+)
+
+// enforceLimits truncates the fields and data of the event to the limits of Umami,
+// so a single oversized event can't make the whole batch fail. Returns the number of changed values.
+func (h *UmamiFeeder) enforceLimits(event *UmamiEvent) int {
+	changed := 0
+	truncate := func(value *string, maxLength int) {
+		if truncated := truncateString(*value, maxLength); truncated != *value {
+			*value = truncated
+			changed++
+		}
+	}
+
+	truncate(&event.Url, maxUrlLength)
+	truncate(&event.Referrer, maxUrlLength)
+	truncate(&event.Hostname, maxHostnameLength)
+	truncate(&event.Title, maxTitleLength)
+	truncate(&event.Name, maxEventNameLength)
+	truncate(&event.Language, maxLanguageLength)
+	truncate(&event.Screen, maxScreenLength)
+	truncate(&event.Tag, maxTagLength)
+	truncate(&event.Id, distinctIdLength)
+
+	if len(event.Data) > 0 {
+		data := make(map[string]any, len(event.Data))
+		budget := maxDataProperties
+		changed += sanitizeData(event.Data, data, "", 1, &budget)
+		event.Data = data
+	}
+
+	if changed > 0 {
+		total := atomic.AddInt64(&h.truncations, int64(changed))
+		h.debugf("event %s truncated to Umami limits (%d values, %d in total)", event.Url, changed, total)
+	}
+	return changed
+}
+
+// sanitizeData copies the data into result, converting values to the types supported by Umami.
+// Nested objects are kept up to maxDataDepth, deeper ones are converted to JSON strings.
+// As Umami flattens nested objects, nested properties are counted against the remaining property budget.
+func sanitizeData(data, result map[string]any, prefix string, depth int, budget *int) int {
+	changed := 0
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Keep the same properties if some have to be dropped.
+
+	for i, key := range keys {
+		if *budget <= 0 {
+			changed += len(keys) - i
+			break
+		}
+
+		safeKey := truncateString(key, maxDataKeyLength-len(prefix))
+		if safeKey != key {
+			changed++
+		}
+
+		if nested, ok := data[key].(map[string]any); ok {
+			if depth < maxDataDepth {
+				child := make(map[string]any, len(nested))
+				changed += sanitizeData(nested, child, prefix+safeKey+".", depth+1, budget)
+				result[safeKey] = child
+				continue
+			}
+			changed++
+		}
+
+		value, ok := sanitizeDataValue(data[key])
+		if !ok {
+			changed++
+			continue
+		}
+		if value != data[key] {
+			changed++
+		}
+		result[safeKey] = value
+		*budget--
+	}
+	return changed
+}
+
+// sanitizeDataValue converts the value to a string, number or boolean, returns false if the value is dropped.
+func sanitizeDataValue(value any) (any, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case string:
+		return truncateString(v, maxDataStringLength), true
+	case bool:
+		return v, true
+	case int:
+		return v, true
+	case int64:
+		return v, true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		scale := math.Pow10(maxNumberPrecision)
+		return math.Round(v*scale) / scale, true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), true
+	case fmt.Stringer:
+		return truncateString(v.String(), maxDataStringLength), true
+	default:
+		// Arrays and deeply nested objects are stored by Umami as strings.
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, false
+		}
+		return truncateString(string(encoded), maxDataStringLength), true
+	}
+}
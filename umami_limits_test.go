@@ -0,0 +1,91 @@
+package traefik_umami_feeder
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEnforceLimits(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	event := &UmamiEvent{
+		Url:   "/" + strings.Repeat("a", 600),
+		Title: "Short",
+		Name:  strings.Repeat("n", 60),
+		Data: map[string]any{
+			"long":   strings.Repeat("x", 600),
+			"number": 3.14159265,
+			"nan":    math.NaN(),
+			"list":   []any{"a", 1},
+			"nested": map[string]any{"a": map[string]any{"b": map[string]any{"c": 1}}},
+			"ok":     true,
+		},
+	}
+
+	changed := feeder.enforceLimits(event)
+	if changed == 0 || feeder.truncations != int64(changed) {
+		t.Fatalf("expected truncations to be counted, got %d and %d", changed, feeder.truncations)
+	}
+	if len(event.Url) != maxUrlLength || len(event.Name) != maxEventNameLength || event.Title != "Short" {
+		t.Fatalf("unexpected fields %d, %d, %s", len(event.Url), len(event.Name), event.Title)
+	}
+
+	data := event.Data
+	if len(data["long"].(string)) != maxDataStringLength || data["number"] != 3.1416 || data["list"] != `["a",1]` || data["ok"] != true {
+		t.Fatalf("unexpected data %v", data)
+	}
+	if _, ok := data["nan"]; ok {
+		t.Fatal("expected NaN to be dropped")
+	}
+	if data["nested"].(map[string]any)["a"].(map[string]any)["b"] != `{"c":1}` {
+		t.Fatalf("expected deep object to be converted to string, got %v", data["nested"])
+	}
+}
+
+func TestEnforceLimitsPropertyCount(t *testing.T) {
+	data := map[string]any{"nested": map[string]any{"a": 1, "b": 2}}
+	for i := range 60 {
+		data["p"+strconv.Itoa(100+i)] = i
+	}
+
+	event := &UmamiEvent{Data: data}
+	(&UmamiFeeder{}).enforceLimits(event)
+	if count := countDataProperties(event.Data); count != maxDataProperties {
+		t.Fatalf("expected %d properties, got %d", maxDataProperties, count)
+	}
+	if _, ok := event.Data["p147"]; !ok {
+		t.Fatal("expected the first properties in key order to be kept")
+	}
+	if _, ok := event.Data["p148"]; ok {
+		t.Fatal("expected the last properties in key order to be dropped")
+	}
+}
+
+func TestSetEventDataKeepsPropertiesUntilLimitsAreEnforced(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	event := &UmamiEvent{}
+	for i := range 60 {
+		feeder.setEventData(event, "p"+strconv.Itoa(100+i), i)
+	}
+	if len(event.Data) != 60 {
+		t.Fatalf("expected all properties to be set, got %d", len(event.Data))
+	}
+
+	feeder.enforceLimits(event)
+	if _, ok := event.Data["p149"]; !ok || len(event.Data) != maxDataProperties {
+		t.Fatalf("expected properties p100 to p149 to be kept, got %d", len(event.Data))
+	}
+}
+
+func countDataProperties(data map[string]any) int {
+	count := 0
+	for _, value := range data {
+		if nested, ok := value.(map[string]any); ok {
+			count += countDataProperties(nested)
+		} else {
+			count++
+		}
+	}
+	return count
+}
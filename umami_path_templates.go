@@ -0,0 +1,160 @@
+package traefik_umami_feeder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of path segments detected as IDs.
+const (
+	pathIdNumeric = "numeric"
+	pathIdUuid    = "uuid"
+	pathIdHex     = "hex"
+	pathIdSlug    = "slug"
+)
+
+var pathIdRegexps = map[string]*regexp.Regexp{
+	pathIdNumeric: regexp.MustCompile(`^[0-9]+$`),
+	pathIdUuid:    regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	pathIdHex:     regexp.MustCompile(`^(?:[0-9a-f]{8,}|[0-9A-F]{8,})$`),
+	pathIdSlug:    regexp.MustCompile(`^(?:[a-z0-9]+-)+[a-z0-9]+$`),
+}
+
+// pathTemplate is a compiled route template, e.g. `/users/:id/orders/:orderId`.
+type pathTemplate struct {
+	segments []string
+	params   []string // Name of the parameter of each segment, empty for literal segments.
+}
+
+// compilePathTemplate parses the template, its literal segments are lowercased if paths are lowercased before
+// they are matched.
+func compilePathTemplate(template string, lowercase bool) (*pathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path template %s must start with /", template)
+	}
+
+	t := &pathTemplate{segments: strings.Split(template[1:], "/")}
+	t.params = make([]string, len(t.segments))
+	for i, segment := range t.segments {
+		name := ""
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			name = segment[1:]
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name = segment[1 : len(segment)-1]
+		default:
+			if lowercase {
+				t.segments[i] = strings.ToLower(segment)
+			}
+			continue
+		}
+		if name == "" {
+			return nil, fmt.Errorf("path template %s has a parameter without name", template)
+		}
+		t.segments[i] = ":" + name
+		t.params[i] = name
+	}
+	return t, nil
+}
+
+// match returns the values of the template parameters, or nil if the path segments don't match the template.
+func (t *pathTemplate) match(segments []string) []string {
+	if len(segments) != len(t.segments) {
+		return nil
+	}
+
+	values := make([]string, len(segments))
+	for i, segment := range segments {
+		if t.params[i] == "" {
+			if segment != t.segments[i] {
+				return nil
+			}
+		} else if segment == "" {
+			return nil
+		}
+		values[i] = segment
+	}
+	return values
+}
+
+func verifyPathIds(kinds []string) error {
+	for _, kind := range kinds {
+		if _, ok := pathIdRegexps[kind]; !ok {
+			return fmt.Errorf("unknown pathIds kind %s", kind)
+		}
+	}
+	return nil
+}
+
+// isPathId checks if the path segment is an ID of one of the configured kinds.
+func (h *UmamiFeeder) isPathId(segment string) bool {
+	for _, kind := range h.pathIds {
+		if !pathIdRegexps[kind].MatchString(segment) {
+			continue
+		}
+		if (kind == pathIdHex || kind == pathIdSlug) && !strings.ContainsAny(segment, "0123456789") {
+			continue // Hex IDs and slugs require a digit, so that words (e.g. `decade`, `about-us`) are kept.
+		}
+		return true
+	}
+	return false
+}
+
+// templatePath collapses IDs in the path, using the first matching template or detecting ID segments,
+// it returns the collapsed path and the raw IDs by parameter name.
+func (h *UmamiFeeder) templatePath(requestPath string) (string, map[string]string) {
+	if len(h.pathTemplates) == 0 && len(h.pathIds) == 0 {
+		return requestPath, nil
+	}
+
+	segments := strings.Split(strings.TrimPrefix(requestPath, "/"), "/")
+	trailingSlash := len(segments) > 1 && segments[len(segments)-1] == ""
+	if trailingSlash {
+		segments = segments[:len(segments)-1]
+	}
+	params := make(map[string]string)
+	addParam := func(name, value string) {
+		key := name
+		for i := 2; params[key] != ""; i++ {
+			key = name + "_" + strconv.Itoa(i)
+		}
+		params[key] = value
+	}
+
+	for _, template := range h.pathTemplates {
+		values := template.match(segments)
+		if values == nil {
+			continue
+		}
+		for i, name := range template.params {
+			if name != "" {
+				addParam(name, values[i])
+			}
+		}
+		return joinPath(template.segments, trailingSlash), params
+	}
+
+	collapsed := false
+	for i, segment := range segments {
+		if h.isPathId(segment) {
+			addParam("id", segment)
+			segments[i] = ":id"
+			collapsed = true
+		}
+	}
+	if !collapsed {
+		return requestPath, nil
+	}
+	return joinPath(segments, trailingSlash), params
+}
+
+// joinPath joins the path segments, keeping the trailing slash of the original path.
+func joinPath(segments []string, trailingSlash bool) string {
+	p := "/" + strings.Join(segments, "/")
+	if trailingSlash {
+		p += "/"
+	}
+	return p
+}
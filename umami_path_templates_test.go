@@ -0,0 +1,78 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTemplatePath(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	err := feeder.verifyConfig(&Config{
+		PathTemplates: []string{"/users/:userId/orders/{orderId}", "/posts/:slug"},
+		PathIds:       []string{pathIdNumeric, pathIdUuid, pathIdHex, pathIdSlug},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path     string
+		expected string
+		params   map[string]string
+	}{
+		{"/users/12345/orders/987", "/users/:userId/orders/:orderId", map[string]string{"userId": "12345", "orderId": "987"}},
+		{"/posts/hello-world", "/posts/:slug", map[string]string{"slug": "hello-world"}},
+		{"/items/12/variants/34", "/items/:id/variants/:id", map[string]string{"id": "12", "id_2": "34"}},
+		{"/files/550e8400-e29b-41d4-a716-446655440000", "/files/:id", map[string]string{"id": "550e8400-e29b-41d4-a716-446655440000"}},
+		{"/commits/9fceb02d0ae598e9", "/commits/:id", map[string]string{"id": "9fceb02d0ae598e9"}},
+		{"/products/iphone-15-pro", "/products/:id", map[string]string{"id": "iphone-15-pro"}},
+		{"/about-us/decade", "/about-us/decade", nil},
+		{"/users/12345", "/users/:id", map[string]string{"id": "12345"}},
+		{"/users/12345/orders/987/", "/users/:userId/orders/:orderId/", map[string]string{"userId": "12345", "orderId": "987"}},
+		{"/users/12/", "/users/:id/", map[string]string{"id": "12"}},
+		{"/", "/", nil},
+	}
+	for _, c := range cases {
+		path, params := feeder.templatePath(c.path)
+		if path != c.expected {
+			t.Errorf("expected %s for %s, got %s", c.expected, c.path, path)
+		}
+		if len(params) != len(c.params) {
+			t.Errorf("expected params %v for %s, got %v", c.params, c.path, params)
+		}
+		for name, value := range c.params {
+			if params[name] != value {
+				t.Errorf("expected param %s=%s for %s, got %v", name, value, c.path, params)
+			}
+		}
+	}
+
+	template, err := compilePathTemplate("/API/Users/:userId", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.match([]string{"api", "users", "42"}) == nil || template.params[2] != "userId" {
+		t.Fatalf("expected literal segments to be lowercased, got %v", template.segments)
+	}
+
+	if err := feeder.verifyConfig(&Config{PathTemplates: []string{"users/:id"}}); err == nil {
+		t.Fatal("should have failed with relative template")
+	}
+	if err := feeder.verifyConfig(&Config{PathIds: []string{"ulid"}}); err == nil {
+		t.Fatal("should have failed with unknown kind")
+	}
+}
+
+func TestPathParamsAsData(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.pathIds = []string{pathIdNumeric}
+	feeder.pathParamsAsData = true
+
+	event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "/users/42?tab=orders", nil))
+	if event.Url != "/users/:id?tab=orders" || event.Data["id"] != "42" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
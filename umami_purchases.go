@@ -0,0 +1,183 @@
+package traefik_umami_feeder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PurchaseRule defines a purchase event, which is sent with data extracted from successful JSON responses.
+type PurchaseRule struct {
+	// Name of the event, defaults to `purchase`.
+	Name string `json:"name"`
+	// URL is a regular expression matched against the request path.
+	URL string `json:"url"`
+	// Methods limits the rule to the given HTTP methods, if empty all methods match.
+	Methods []string `json:"methods"`
+	// Revenue is the JSON path of the order total, e.g. `total` or `order.amount`.
+	Revenue string `json:"revenue"`
+	// Currency is the JSON path of the ISO 4217 currency code.
+	Currency string `json:"currency"`
+	// DefaultCurrency is used if Currency is not set or not found in the response.
+	DefaultCurrency string `json:"defaultCurrency"`
+	// OrderId is the JSON path of the order ID, sent as `order_id`.
+	OrderId string `json:"orderId"`
+	// Data is a map of event data property to JSON path.
+	Data map[string]string `json:"data"`
+}
+
+type purchaseRule struct {
+	PurchaseRule
+	url *regexp.Regexp
+}
+
+var currencyRegexp = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+func compilePurchaseRule(rule PurchaseRule) (*purchaseRule, error) {
+	if rule.Name == "" {
+		rule.Name = "purchase"
+	}
+	if len(rule.Name) > maxEventNameLength {
+		return nil, fmt.Errorf("name must not exceed %d characters", maxEventNameLength)
+	}
+	if rule.Revenue == "" {
+		return nil, errors.New("revenue is required")
+	}
+	if rule.Currency == "" && rule.DefaultCurrency == "" {
+		return nil, errors.New("currency or defaultCurrency is required")
+	}
+	if rule.DefaultCurrency != "" && !currencyRegexp.MatchString(rule.DefaultCurrency) {
+		return nil, fmt.Errorf("invalid defaultCurrency %s", rule.DefaultCurrency)
+	}
+
+	r, err := regexp.Compile(rule.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile url %s: %w", rule.URL, err)
+	}
+
+	return &purchaseRule{PurchaseRule: rule, url: r}, nil
+}
+
+// matchPurchaseRule returns the first purchase rule matching the request, or nil.
+func (h *UmamiFeeder) matchPurchaseRule(req *http.Request) *purchaseRule {
+	for _, rule := range h.purchaseRules {
+		if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(method string) bool {
+			return strings.EqualFold(method, req.Method)
+		}) {
+			continue
+		}
+		if rule.url.MatchString(req.URL.Path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// isJsonMediaType returns true for `application/json` and structured syntax suffixes like `application/ld+json`.
+func isJsonMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonPath returns the value at a dot-separated path (e.g. `order.items.0.price`, optionally prefixed with `$.`),
+// or nil if it doesn't exist.
+func jsonPath(value any, path string) any {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return value
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			value = v[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// jsonNumber converts a JSON number or numeric string to float64.
+func jsonNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// applyPurchaseRule returns the purchase event extracted from the captured JSON response, or nil.
+func (h *UmamiFeeder) applyPurchaseRule(rw *ResponseWrapper, pageview *UmamiEvent) *UmamiEvent {
+	rule := rw.purchaseRule
+	if rule == nil || rw.statusCode < 200 || rw.statusCode >= 300 || !isJsonMediaType(rw.captured) {
+		return nil
+	}
+
+	var body any
+	decoder := json.NewDecoder(bytes.NewReader(rw.decodedBody()))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		h.debugf("failed to parse purchase response of %s: %v", rw.request.URL.Path, err)
+		return nil
+	}
+
+	revenue, ok := jsonNumber(jsonPath(body, rule.Revenue))
+	if !ok {
+		h.debugf("no revenue found at %s in response of %s", rule.Revenue, rw.request.URL.Path)
+		return nil
+	}
+
+	currency := rule.DefaultCurrency
+	if rule.Currency != "" {
+		if value, ok := jsonPath(body, rule.Currency).(string); ok && currencyRegexp.MatchString(value) {
+			currency = value
+		}
+	}
+	if currency == "" {
+		h.debugf("no currency found at %s in response of %s", rule.Currency, rw.request.URL.Path)
+		return nil
+	}
+
+	event := *pageview
+	event.Name = rule.Name
+	event.Data = maps.Clone(pageview.Data)
+	h.setEventData(&event, "revenue", revenue)
+	h.setEventData(&event, "currency", strings.ToUpper(currency))
+	if rule.OrderId != "" {
+		if value := jsonPath(body, rule.OrderId); value != nil {
+			h.setEventData(&event, "order_id", fmt.Sprint(value))
+		}
+	}
+	for property, path := range rule.Data {
+		switch value := jsonPath(body, path).(type) {
+		case nil, map[string]any, []any:
+			continue
+		case json.Number:
+			if number, err := value.Float64(); err == nil {
+				h.setEventData(&event, property, number)
+			}
+		default:
+			h.setEventData(&event, property, value)
+		}
+	}
+
+	h.debugf("purchase '%s' of %.2f %s for %s", event.Name, revenue, currency, rw.request.URL.Path)
+	return &event
+}
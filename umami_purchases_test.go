@@ -0,0 +1,88 @@
+package traefik_umami_feeder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJsonPath(t *testing.T) {
+	body := map[string]any{
+		"order": map[string]any{"total": 49.9},
+		"items": []any{map[string]any{"sku": "A-1"}},
+	}
+
+	tests := map[string]any{
+		"order.total":   49.9,
+		"$.order.total": 49.9,
+		"items.0.sku":   "A-1",
+		"items.1.sku":   nil,
+		"order.missing": nil,
+		"order.total.x": nil,
+	}
+	for path, expected := range tests {
+		if value := jsonPath(body, path); value != expected {
+			t.Errorf("jsonPath(%s) = %v, expected %v", path, value, expected)
+		}
+	}
+}
+
+func TestPurchases(t *testing.T) {
+	response := `{"orderId": 1042, "total": "49.90", "currency": "eur", "customer": {"country": "DE"}}`
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(rw)
+		_, _ = writer.Write([]byte(response))
+		_ = writer.Close()
+	}))
+	feeder.trackAllResources = true
+	feeder.purchaseBodyLimit = 1024
+	err := feeder.verifyConfig(&Config{PurchaseBodyLimit: 1024, Purchases: []PurchaseRule{{
+		URL:      "^/api/orders$",
+		Methods:  []string{"POST"},
+		Revenue:  "total",
+		Currency: "currency",
+		OrderId:  "orderId",
+		Data:     map[string]string{"country": "customer.country"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://example.com/api/orders", bytes.NewReader(nil)))
+	if len(feeder.queue) != 2 {
+		t.Fatalf("expected pageview and purchase, got %d", len(feeder.queue))
+	}
+	<-feeder.queue
+	event := (<-feeder.queue).Payload
+	if event.Name != "purchase" || event.Data["revenue"] != 49.9 || event.Data["currency"] != "EUR" ||
+		event.Data["order_id"] != "1042" || event.Data["country"] != "DE" {
+		t.Fatalf("unexpected purchase %+v", event)
+	}
+
+	feeder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/api/orders", nil))
+	if len(feeder.queue) != 1 {
+		t.Fatalf("expected only pageview for unmatched method, got %d", len(feeder.queue))
+	}
+	<-feeder.queue
+}
+
+func TestCompilePurchaseRule(t *testing.T) {
+	if _, err := compilePurchaseRule(PurchaseRule{URL: "^/checkout$", Revenue: "total"}); err == nil {
+		t.Fatal("expected error without currency")
+	}
+	if _, err := compilePurchaseRule(PurchaseRule{URL: "^/checkout$", Revenue: "total", DefaultCurrency: "euro"}); err == nil {
+		t.Fatal("expected error for invalid default currency")
+	}
+
+	rule, err := compilePurchaseRule(PurchaseRule{URL: "^/checkout$", Revenue: "total", DefaultCurrency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "purchase" {
+		t.Fatalf("expected default name, got %s", rule.Name)
+	}
+}
@@ -1,9 +1,12 @@
 package traefik_umami_feeder
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -152,3 +155,265 @@ func assertIgnoreUa(t *testing.T, plugin *UmamiFeeder, expected bool, ua string)
 		t.Fatalf("expected %v for %s", expected, ua)
 	}
 }
+
+func newTestFeeder(next http.Handler) *UmamiFeeder {
+	return &UmamiFeeder{
+		next:      next,
+		isEnabled: true,
+		queue:     make(chan *SendBody, 10),
+		websites:  map[string]string{"example.com": "website"},
+	}
+}
+
+func serveTestRequest(t *testing.T, feeder *UmamiFeeder, req *http.Request) *UmamiEvent {
+	t.Helper()
+	feeder.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case body := <-feeder.queue:
+		return body.Payload
+	default:
+		return nil
+	}
+}
+
+func TestDataFromResponse(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = rw.Write([]byte("<html></html>"))
+	}))
+	feeder.dataFromResponse = responseDataProperties
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/about", nil)
+	event := serveTestRequest(t, feeder, req)
+	if event == nil {
+		t.Fatal("expected event to be submitted")
+	}
+
+	if event.Data["method"] != http.MethodPost || event.Data["response_bytes"] != int64(13) ||
+		event.Data["protocol"] != "HTTP/1.1" || event.Data["content_type"] != "text/html" {
+		t.Fatalf("unexpected data %v", event.Data)
+	}
+	if _, ok := event.Data["response_time"]; !ok {
+		t.Fatalf("expected response_time in %v", event.Data)
+	}
+	if _, ok := event.Data["tls_version"]; ok {
+		t.Fatalf("unexpected tls_version in %v", event.Data)
+	}
+}
+
+func TestDataFromHeadersAndCookies(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.dataFromHeaders = map[string]string{"X-User-Plan": "plan", "X-App-Version": "version"}
+	feeder.dataFromCookies = map[string]string{"ab_bucket": "bucket", "locale": "locale"}
+	feeder.hashData = []string{"bucket"}
+	feeder.hashSalt = "salt"
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("X-User-Plan", "pro")
+	req.Header.Set("X-App-Version", strings.Repeat("1", 600))
+	req.AddCookie(&http.Cookie{Name: "ab_bucket", Value: "B"})
+
+	event := serveTestRequest(t, feeder, req)
+	if event == nil {
+		t.Fatal("expected event to be submitted")
+	}
+
+	if event.Data["plan"] != "pro" || event.Data["bucket"] != hashValue("salt", "B") {
+		t.Fatalf("unexpected data %v", event.Data)
+	}
+	if version, _ := event.Data["version"].(string); len(version) != maxDataStringLength {
+		t.Fatalf("expected version to be truncated, got %d characters", len(version))
+	}
+	if _, ok := event.Data["locale"]; ok {
+		t.Fatalf("unexpected locale in %v", event.Data)
+	}
+}
+
+func TestExtractTitle(t *testing.T) {
+	page := "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>\n  Hello &amp; Welcome\n</title></head>" +
+		"<body>" + strings.Repeat("<p>content</p>", 1000) + "</body></html>"
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write([]byte(page))
+	_ = writer.Close()
+
+	cases := []struct {
+		contentType string
+		encoding    string
+		body        []byte
+		expected    string
+	}{
+		{"text/html; charset=utf-8", "", []byte(page), "Hello & Welcome"},
+		{"", "", []byte(page), "Hello & Welcome"},
+		{"text/html", "gzip", compressed.Bytes(), "Hello & Welcome"},
+		{"text/html", "br", compressed.Bytes(), ""},
+		{"application/json", "", []byte(`{"title":"<title>Nope</title>"}`), ""},
+	}
+	for _, c := range cases {
+		feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if c.contentType != "" {
+				rw.Header().Set("Content-Type", c.contentType)
+			}
+			if c.encoding != "" {
+				rw.Header().Set("Content-Encoding", c.encoding)
+			}
+			// Write in chunks to make sure the body is captured across calls.
+			for i := 0; i < len(c.body); i += 100 {
+				_, _ = rw.Write(c.body[i:min(i+100, len(c.body))])
+			}
+		}))
+		feeder.extractTitle = true
+		feeder.bodyLimit = 1024
+
+		event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		if event == nil || event.Title != c.expected {
+			t.Fatalf("expected title %q for %s %s, got %v", c.expected, c.contentType, c.encoding, event)
+		}
+	}
+}
+
+func TestDistinctId(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.distinctIdHeader = "Remote-User"
+	feeder.distinctIdCookie = "uid"
+	feeder.hashSalt = "salt"
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Remote-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "uid", Value: "bob"})
+	if event := serveTestRequest(t, feeder, req); event.Id != hashValue("salt", "alice")[:distinctIdLength] {
+		t.Fatalf("expected id from header, got %s", event.Id)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "uid", Value: "bob"})
+	if event := serveTestRequest(t, feeder, req); event.Id != hashValue("salt", "bob")[:distinctIdLength] {
+		t.Fatalf("expected id from cookie, got %s", event.Id)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if event := serveTestRequest(t, feeder, req); event.Id != "" {
+		t.Fatalf("expected no id, got %s", event.Id)
+	}
+}
+
+func TestDistinctIdRequiresSalt(t *testing.T) {
+	feeder := &UmamiFeeder{}
+	if err := feeder.verifyConfig(&Config{DistinctIdHeader: "Remote-User"}); err == nil {
+		t.Fatal("should have failed without hashSalt")
+	}
+	if err := feeder.verifyConfig(&Config{HashData: []string{"email"}}); err == nil {
+		t.Fatal("should have failed without hashSalt")
+	}
+	if err := feeder.verifyConfig(&Config{DistinctIdHeader: "Remote-User", HashSalt: "salt"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackRedirects(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "/new-path?utm_source=mail&gclid=abc", http.StatusMovedPermanently)
+	})
+
+	feeder := newTestFeeder(next)
+	if event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)); event == nil || event.Name != "" {
+		t.Fatalf("expected pageview by default, got %v", event)
+	}
+
+	feeder.trackRedirects = trackRedirectsSkip
+	if event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)); event != nil {
+		t.Fatalf("expected redirect to be skipped, got %v", event)
+	}
+
+	feeder.trackRedirects = trackRedirectsEvent
+	feeder.stripClickIds = true
+	event := serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "/old", nil))
+	if event == nil || event.Name != "redirect" {
+		t.Fatalf("expected redirect event, got %v", event)
+	}
+	if event.Data["from"] != "/old" || event.Data["to"] != "/new-path?utm_source=mail" || event.Data["status_code"] != http.StatusMovedPermanently {
+		t.Fatalf("unexpected data %v", event.Data)
+	}
+}
+
+func TestEventTag(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.Enabled = false
+	cfg.Tag = "prod"
+	cfg.WebsiteTags = map[string]string{"Staging.Example.com": "staging"}
+
+	handler, err := New(context.Background(), nil, cfg, "umami-feeder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder := handler.(*UmamiFeeder)
+	if tag := feeder.eventTag("example.com"); tag != "prod" {
+		t.Fatalf("expected middleware tag, got %s", tag)
+	}
+	if tag := feeder.eventTag("staging.example.com"); tag != "staging" {
+		t.Fatalf("expected website tag, got %s", tag)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                                "",
+		"en-US":                           "en-US",
+		"en-us,en;q=0.9":                  "en-US",
+		"*;q=0.1, de;q=0.9":               "de",
+		"fr;q=0.5, de-at;q=0.8, en;q=0.8": "de-AT",
+		"zh-hant-tw;q=0.9, en;q=0.3":      "zh-Hant-TW",
+		"es-419":                          "es-419",
+		"iw-il":                           "he-IL",
+		"en_GB":                           "en-GB",
+		"de;q=0, *":                       "",
+		"1234, ru":                        "ru",
+		"en;q=abc, fr;q=0.2":              "fr",
+	}
+	for acceptLanguage, expected := range cases {
+		if actual := parseAcceptLanguage(acceptLanguage); actual != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, acceptLanguage, actual)
+		}
+	}
+}
+
+func TestPreferContentLanguage(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Language", "de-de, en")
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.preferContentLanguage = true
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	if event := serveTestRequest(t, feeder, req); event.Language != "de-DE" {
+		t.Fatalf("expected content language, got %s", event.Language)
+	}
+}
+
+func TestSubmitOnWriteHeader(t *testing.T) {
+	var queued int
+	feeder := newTestFeeder(nil)
+	feeder.next = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		queued = len(feeder.queue) // A streamed response is reported before it ends.
+	})
+
+	serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if queued != 1 {
+		t.Fatalf("expected request to be submitted on WriteHeader, got %d events", queued)
+	}
+
+	feeder.dataFromResponse = []string{"response_bytes"}
+	serveTestRequest(t, feeder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if queued != 0 {
+		t.Fatalf("expected submission to be deferred for response_bytes, got %d events", queued)
+	}
+}
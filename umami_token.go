@@ -1,6 +1,9 @@
 package traefik_umami_feeder
 
-import "context"
+import (
+	"context"
+	"net/http"
+)
 
 type authRequest struct {
 	Username string `json:"username"`
@@ -11,12 +14,12 @@ type authResponse struct {
 	Token string `json:"token"`
 }
 
-func getToken(ctx context.Context, umamiHost, umamiUsername, umamiPassword string) (string, error) {
+func getToken(ctx context.Context, umamiHost string, headers http.Header, umamiUsername, umamiPassword string) (string, error) {
 	var result authResponse
 	err := sendRequestAndParse(ctx, umamiHost+"/api/auth/login", authRequest{
 		Username: umamiUsername,
 		Password: umamiPassword,
-	}, nil, &result)
+	}, headers.Clone(), &result)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,317 @@
+package traefik_umami_feeder
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Query parameter handling modes.
+const (
+	queryParamsKeep  = "keep"
+	queryParamsDrop  = "drop"
+	queryParamsAllow = "allow"
+	queryParamsDeny  = "deny"
+)
+
+// clickIdParams are query parameters added by ad networks to identify clicks.
+var clickIdParams = []string{
+	"gclid", "gclsrc", "gbraid", "wbraid", "dclid", "fbclid", "msclkid", "twclid", "ttclid", "li_fat_id",
+	"yclid", "mc_eid", "igshid", "_hsenc", "_hsmi",
+}
+
+func verifyQueryParams(mode string, patterns []string) error {
+	switch mode {
+	case "", queryParamsKeep, queryParamsDrop:
+	case queryParamsAllow, queryParamsDeny:
+		if len(patterns) == 0 {
+			return fmt.Errorf("queryParamsList is required for queryParams mode %s", mode)
+		}
+	default:
+		return fmt.Errorf("unknown queryParams mode %s", mode)
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid queryParamsList pattern %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Trailing slash policies.
+const (
+	trailingSlashKeep   = "keep"
+	trailingSlashAdd    = "add"
+	trailingSlashRemove = "remove"
+)
+
+// normalizedRequest returns a shallow copy of the request with its path normalized, which is used to match rules
+// and to report the request. The request passed to the next handler is not modified.
+func (h *UmamiFeeder) normalizedRequest(req *http.Request) *http.Request {
+	u := *req.URL
+	stripFragment(&u)
+	h.normalizePath(&u)
+	if u.Path == req.URL.Path && u.RawPath == req.URL.RawPath && u.RawQuery == req.URL.RawQuery &&
+		u.Fragment == req.URL.Fragment {
+		return req
+	}
+
+	normalized := *req
+	normalized.URL = &u
+	return &normalized
+}
+
+// stripFragment removes the fragment of u. Browsers never send it, but a raw '#' sent by other clients is not split
+// off the request URI, so it ends up in the path, or in the query if the URI has one.
+func stripFragment(u *url.URL) {
+	u.Fragment, u.RawFragment = "", ""
+	if i := strings.IndexByte(u.RawQuery, '#'); i >= 0 {
+		u.RawQuery = u.RawQuery[:i]
+	}
+
+	// An encoded '#' is part of the path, only a raw one starts the fragment and sets RawPath.
+	if i := strings.IndexByte(u.RawPath, '#'); i >= 0 {
+		if unescaped, err := url.PathUnescape(u.RawPath[:i]); err == nil {
+			u.Path, u.RawPath = unescaped, u.RawPath[:i]
+			u.RawQuery, u.ForceQuery = "", false // The query was part of the fragment.
+		}
+	}
+}
+
+// normalizePath applies the URL normalization rules to the path of u.
+func (h *UmamiFeeder) normalizePath(u *url.URL) {
+	if h.normalizeEncoding {
+		if strings.Contains(strings.ToLower(u.RawPath), "%2f") {
+			u.RawPath = percentEncodingRegexp.ReplaceAllStringFunc(u.RawPath, strings.ToUpper)
+		} else {
+			u.RawPath = "" // The path is re-encoded from its decoded form.
+		}
+	}
+
+	if u.RawPath == "" {
+		u.Path = h.normalizeSegments(u.Path)
+		return
+	}
+
+	// Encoded slashes must stay encoded, so the rules are applied to the escaped path.
+	raw := h.normalizeSegments(u.RawPath)
+	if h.lowercasePaths {
+		raw = percentEncodingRegexp.ReplaceAllStringFunc(raw, strings.ToUpper)
+	}
+	if unescaped, err := url.PathUnescape(raw); err == nil {
+		u.Path, u.RawPath = unescaped, raw
+	}
+}
+
+// normalizeSegments applies the slash, case and index file rules to a path.
+func (h *UmamiFeeder) normalizeSegments(p string) string {
+	if h.mergeSlashes {
+		for strings.Contains(p, "//") {
+			p = strings.ReplaceAll(p, "//", "/")
+		}
+	}
+	if h.lowercasePaths {
+		p = strings.ToLower(p)
+	}
+	if dir, file := path.Split(p); slices.Contains(h.indexFiles, file) {
+		p = dir
+	}
+
+	switch h.trailingSlash {
+	case trailingSlashAdd:
+		if !strings.HasSuffix(p, "/") && path.Ext(p) == "" {
+			p += "/"
+		}
+	case trailingSlashRemove:
+		if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+			p = trimmed
+		}
+	}
+	return p
+}
+
+var percentEncodingRegexp = regexp.MustCompile(`%[0-9a-fA-F]{2}`)
+
+// trackedURL returns the URL of the request as it is reported to Umami, and the IDs collapsed by path templating.
+// The path is expected to be normalized already, see normalizedRequest.
+func (h *UmamiFeeder) trackedURL(req *http.Request) (string, map[string]string) {
+	u := *req.URL
+
+	if h.stripLocalePrefix {
+		if _, stripped, ok := h.pathLocale(u.Path); ok {
+			u.Path = stripped
+			u.RawPath = ""
+		}
+	}
+
+	templated, params := h.templatePath(u.Path)
+	if params != nil {
+		u.Path = templated
+		u.RawPath = ""
+	}
+
+	u.RawQuery = h.filterQuery(u.RawQuery)
+	u.ForceQuery = false
+	return u.String(), params
+}
+
+// filterQuery removes query parameters according to the queryParams mode, preserving the order of the others.
+func (h *UmamiFeeder) filterQuery(rawQuery string) string {
+	if rawQuery == "" || h.queryParams == queryParamsDrop {
+		return ""
+	}
+	if (h.queryParams == "" || h.queryParams == queryParamsKeep) && !h.stripClickIds {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+
+		if h.keepQueryParam(key) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func (h *UmamiFeeder) keepQueryParam(key string) bool {
+	if h.stripClickIds && slices.Contains(clickIdParams, strings.ToLower(key)) {
+		return false
+	}
+
+	switch h.queryParams {
+	case queryParamsAllow:
+		return matchAnyPattern(h.queryParamsList, key)
+	case queryParamsDeny:
+		return !matchAnyPattern(h.queryParamsList, key)
+	}
+	return true
+}
+
+// matchAnyPattern checks if the value matches any of the glob patterns, see [path.Match].
+func matchAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pathLocale detects a locale prefix of the path, it returns the canonical language tag
+// and the path without the prefix.
+func (h *UmamiFeeder) pathLocale(requestPath string) (string, string, bool) {
+	var locale, rest string
+	switch {
+	case h.localePattern != nil:
+		match := h.localePattern.FindStringSubmatchIndex(requestPath)
+		if match == nil || match[0] != 0 || len(match) < 4 || match[2] < 0 {
+			return "", "", false
+		}
+		locale, rest = requestPath[match[2]:match[3]], requestPath[match[1]:]
+	case len(h.localePrefixes) > 0:
+		segment, remaining, _ := strings.Cut(strings.TrimPrefix(requestPath, "/"), "/")
+		idx := slices.IndexFunc(h.localePrefixes, func(prefix string) bool {
+			return strings.EqualFold(prefix, segment)
+		})
+		if idx == -1 {
+			return "", "", false
+		}
+		locale, rest = segment, remaining
+	default:
+		return "", "", false
+	}
+
+	language := canonicalLanguageTag(locale)
+	if language == "" {
+		return "", "", false
+	}
+	return language, "/" + strings.TrimPrefix(rest, "/"), true
+}
+
+// Redirect handling modes.
+const (
+	trackRedirectsPageview = "pageview"
+	trackRedirectsSkip     = "skip"
+	trackRedirectsEvent    = "event"
+)
+
+func isRedirect(statusCode int) bool {
+	return statusCode >= 300 && statusCode < 400 && statusCode != http.StatusNotModified
+}
+
+// trackedLocation returns the redirect target as it is reported to Umami, with query parameters filtered.
+func (h *UmamiFeeder) trackedLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	u.RawQuery = h.filterQuery(u.RawQuery)
+	u.ForceQuery = false
+	return u.String()
+}
+
+// trackedReferrer returns the referrer of the request as it is reported to Umami.
+func (h *UmamiFeeder) trackedReferrer(req *http.Request, hostname, websiteId string) string {
+	referrer := req.Referer()
+	if referrer == "" && h.referrerFromOrigin && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		if origin := req.Header.Get("Origin"); origin != "null" {
+			referrer = origin
+		}
+	}
+	if referrer == "" {
+		return ""
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return referrer
+	}
+
+	if h.ignoreSelfReferrer && h.isInternalReferrer(parseDomainFromHost(u.Host), hostname, websiteId) {
+		return ""
+	}
+
+	if h.stripReferrerQuery {
+		u.RawQuery = ""
+		u.ForceQuery = false
+		u.Fragment = ""
+		u.RawFragment = ""
+		return u.String()
+	}
+	return referrer
+}
+
+// isInternalReferrer checks if the referrer domain belongs to the same website or to one of the internal domains.
+func (h *UmamiFeeder) isInternalReferrer(referrerDomain, hostname, websiteId string) bool {
+	if referrerDomain == hostname {
+		return true
+	}
+
+	for _, domain := range h.internalDomains {
+		domain = strings.ToLower(domain)
+		if referrerDomain == domain || strings.HasSuffix(referrerDomain, "."+domain) {
+			return true
+		}
+	}
+
+	h.websitesMutex.RLock()
+	defer h.websitesMutex.RUnlock()
+	referrerWebsiteId, ok := h.websites[referrerDomain]
+	return ok && referrerWebsiteId == websiteId
+}
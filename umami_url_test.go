@@ -0,0 +1,200 @@
+package traefik_umami_feeder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrackedURLQueryParams(t *testing.T) {
+	const target = "/search?q=secret&utm_source=news&page=2&gclid=abc&ref=home&utm_medium=mail"
+
+	cases := []struct {
+		mode          string
+		patterns      []string
+		stripClickIds bool
+		expected      string
+	}{
+		{queryParamsKeep, nil, false, target},
+		{"", nil, true, "/search?q=secret&utm_source=news&page=2&ref=home&utm_medium=mail"},
+		{queryParamsDrop, nil, false, "/search"},
+		{queryParamsAllow, []string{"utm_*", "ref", "page"}, false, "/search?utm_source=news&page=2&ref=home&utm_medium=mail"},
+		{queryParamsDeny, []string{"q", "utm_*"}, true, "/search?page=2&ref=home"},
+		{queryParamsAllow, []string{"email"}, false, "/search"},
+	}
+	for _, c := range cases {
+		feeder := &UmamiFeeder{queryParams: c.mode, queryParamsList: c.patterns, stripClickIds: c.stripClickIds}
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if actual, _ := feeder.trackedURL(req); actual != c.expected {
+			t.Fatalf("expected %s for mode %s, got %s", c.expected, c.mode, actual)
+		}
+	}
+}
+
+func TestInvalidQueryParams(t *testing.T) {
+	if err := verifyQueryParams("whitelist", nil); err == nil {
+		t.Fatal("should have failed with unknown mode")
+	}
+	if err := verifyQueryParams(queryParamsAllow, nil); err == nil {
+		t.Fatal("should have failed without patterns")
+	}
+	if err := verifyQueryParams(queryParamsDeny, []string{"[utm"}); err == nil {
+		t.Fatal("should have failed with invalid pattern")
+	}
+}
+
+func TestTrackedReferrer(t *testing.T) {
+	feeder := &UmamiFeeder{
+		websites:           map[string]string{"example.com": "website", "www.example.com": "website", "other.com": "other"},
+		stripReferrerQuery: true,
+		ignoreSelfReferrer: true,
+		internalDomains:    []string{"auth.example.net"},
+		referrerFromOrigin: true,
+	}
+
+	cases := []struct {
+		method   string
+		referrer string
+		origin   string
+		expected string
+	}{
+		{http.MethodGet, "https://google.com/search?q=secret#top", "", "https://google.com/search"},
+		{http.MethodGet, "https://example.com/about", "", ""},
+		{http.MethodGet, "https://www.example.com/about", "", ""},
+		{http.MethodGet, "https://login.auth.example.net/callback?code=1", "", ""},
+		{http.MethodGet, "https://other.com/page?a=1", "", "https://other.com/page"},
+		{http.MethodPost, "", "https://partner.com", "https://partner.com"},
+		{http.MethodPost, "", "null", ""},
+		{http.MethodGet, "", "https://partner.com", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "http://example.com/", nil)
+		if c.referrer != "" {
+			req.Header.Set("Referer", c.referrer)
+		}
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+
+		if actual := feeder.trackedReferrer(req, "example.com", "website"); actual != c.expected {
+			t.Fatalf("expected %q for %s %s, got %q", c.expected, c.method, c.referrer, actual)
+		}
+	}
+}
+
+func TestPathLocale(t *testing.T) {
+	prefixes := &UmamiFeeder{localePrefixes: []string{"de", "fr-ca"}, stripLocalePrefix: true}
+	pattern := &UmamiFeeder{stripLocalePrefix: true}
+	if err := pattern.verifyConfig(&Config{LocalePattern: `^/([a-z]{2}(?:-[a-z]{2})?)(?:/|$)`}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		feeder   *UmamiFeeder
+		target   string
+		language string
+		url      string
+	}{
+		{prefixes, "/de/about?page=2", "de", "/about?page=2"},
+		{prefixes, "/fr-CA/", "fr-CA", "/"},
+		{prefixes, "/de", "de", "/"},
+		{prefixes, "/design/about", "", "/design/about"},
+		{prefixes, "/en/about", "", "/en/about"},
+		{pattern, "/pt-br/docs", "pt-BR", "/docs"},
+		{pattern, "/docs/en", "", "/docs/en"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.target, nil)
+		language, _, _ := c.feeder.pathLocale(req.URL.Path)
+		if language != c.language {
+			t.Fatalf("expected language %q for %s, got %q", c.language, c.target, language)
+		}
+		if url, _ := c.feeder.trackedURL(req); url != c.url {
+			t.Fatalf("expected url %s for %s, got %s", c.url, c.target, url)
+		}
+	}
+
+	if err := pattern.verifyConfig(&Config{LocalePattern: `^/[a-z]{2}/`}); err == nil {
+		t.Fatal("should have failed without capture group")
+	}
+}
+
+func TestTrackedURLNormalization(t *testing.T) {
+	feeder := &UmamiFeeder{
+		trailingSlash:     trailingSlashRemove,
+		lowercasePaths:    true,
+		indexFiles:        []string{"index.html", "index.php"},
+		normalizeEncoding: true,
+		mergeSlashes:      true,
+	}
+
+	cases := map[string]string{
+		"/about":              "/about",
+		"/about/":             "/about",
+		"/About":              "/about",
+		"/about/index.html":   "/about",
+		"/about?":             "/about",
+		"//about///team/":     "/about/team",
+		"/":                   "/",
+		"/index.php":          "/",
+		"/caf%c3%a9":          "/caf%C3%A9",
+		"/%7Euser":            "/~user",
+		"/a%2fb":              "/a%2Fb",
+		"//Files/A%2fB/":      "/files/a%2Fb",
+		"/search/?q=Shoes&x=": "/search?q=Shoes&x=",
+		"/about#team":         "/about",
+		"/about#team?x=1":     "/about",
+		"/about?x=1#team":     "/about?x=1",
+		"/c%23/":              "/c%23",
+	}
+	for target, expected := range cases {
+		req := feeder.normalizedRequest(httptest.NewRequest(http.MethodGet, target, nil))
+		if actual, _ := feeder.trackedURL(req); actual != expected {
+			t.Errorf("expected %s for %s, got %s", expected, target, actual)
+		}
+	}
+
+	feeder = &UmamiFeeder{trailingSlash: trailingSlashAdd}
+	for target, expected := range map[string]string{"/about": "/about/", "/files/report.pdf": "/files/report.pdf", "/": "/"} {
+		req := feeder.normalizedRequest(httptest.NewRequest(http.MethodGet, target, nil))
+		if actual, _ := feeder.trackedURL(req); actual != expected {
+			t.Errorf("expected %s for %s, got %s", expected, target, actual)
+		}
+	}
+
+	if err := feeder.verifyConfig(&Config{TrailingSlash: "always"}); err == nil {
+		t.Fatal("should have failed with unknown trailingSlash policy")
+	}
+}
+
+func TestRulesMatchNormalizedPath(t *testing.T) {
+	feeder := newTestFeeder(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/Order/Complete/" {
+			t.Errorf("expected the next handler to receive the original path, got %s", req.URL.Path)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	feeder.trailingSlash = trailingSlashRemove
+	feeder.lowercasePaths = true
+	err := feeder.verifyConfig(&Config{
+		Events:      []EventRule{{Name: "order", URL: "^/order/complete$", Pageview: true}},
+		Conversions: []ConversionRule{{Name: "purchase", URL: "^/order/complete$"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/Order/Complete/", nil))
+	var names []string
+	for len(feeder.queue) > 0 {
+		event := (<-feeder.queue).Payload
+		if event.Url != "/order/complete" {
+			t.Fatalf("expected normalized url, got %s", event.Url)
+		}
+		names = append(names, event.Name)
+	}
+	if len(names) != 3 || names[1] != "order" || names[2] != "purchase" {
+		t.Fatalf("expected pageview, event and conversion, got %v", names)
+	}
+}
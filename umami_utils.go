@@ -3,16 +3,21 @@ package traefik_umami_feeder
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const defaultUserAgent = "traefik-umami-feeder"
+
 func sendRequest(ctx context.Context, url string, body any, headers http.Header) (*http.Response, error) {
 	var req *http.Request
 	var err error
@@ -35,6 +40,9 @@ func sendRequest(ctx context.Context, url string, body any, headers http.Header)
 	if headers != nil {
 		req.Header = headers
 	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", defaultUserAgent)
+	}
 
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
@@ -62,6 +70,16 @@ func sendRequest(ctx context.Context, url string, body any, headers http.Header)
 	return resp, nil
 }
 
+// withAuthorization returns a copy of headers with the bearer token set.
+func withAuthorization(headers http.Header, token string) http.Header {
+	headers = headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("Authorization", "Bearer "+token)
+	return headers
+}
+
 func sendRequestAndParse(ctx context.Context, url string, body any, headers http.Header, value any) error {
 	resp, err := sendRequest(ctx, url, body, headers)
 	if err != nil {
@@ -93,16 +111,105 @@ func parseDomainFromHost(host string) string {
 	return strings.ToLower(host)
 }
 
-const parseAcceptLanguagePattern = `([a-zA-Z\-]+)(?:;q=\d\.\d)?(?:,\s)?`
+// parseAcceptLanguage returns the language with the highest quality value (RFC 9110, section 12.5.4),
+// the first one wins on equal quality. Wildcards and invalid tags are ignored.
+func parseAcceptLanguage(acceptLanguage string) string {
+	best, bestQuality := "", 0.0
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(item, ";")
+		tag = canonicalLanguageTag(tag)
+		if tag == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				q, err := strconv.ParseFloat(value, 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				quality = q
+			}
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = tag, quality
+		}
+	}
+	return best
+}
+
+// parseContentLanguage returns the first language of the Content-Language header.
+func parseContentLanguage(contentLanguage string) string {
+	tag, _, _ := strings.Cut(contentLanguage, ",")
+	return canonicalLanguageTag(tag)
+}
 
-var parseAcceptLanguageRegexp = regexp.MustCompile(parseAcceptLanguagePattern)
+// deprecatedLanguages maps deprecated ISO 639 codes to their replacements.
+var deprecatedLanguages = map[string]string{"iw": "he", "in": "id", "ji": "yi", "jw": "jv", "mo": "ro"}
 
-func parseAcceptLanguage(acceptLanguage string) string {
-	matches := parseAcceptLanguageRegexp.FindAllStringSubmatch(acceptLanguage, -1)
-	if len(matches) == 0 {
+// canonicalLanguageTag formats a BCP 47 language tag using the case conventions of RFC 5646
+// (language lowercase, script title case, region uppercase), or returns an empty string if the tag is invalid.
+func canonicalLanguageTag(tag string) string {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if tag == "" || tag == "*" {
 		return ""
 	}
-	return matches[0][1]
+
+	subtags := strings.Split(strings.ToLower(tag), "-")
+	for i, subtag := range subtags {
+		if subtag == "" || len(subtag) > 8 || strings.Trim(subtag, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			return ""
+		}
+
+		switch {
+		case i == 0:
+			if len(subtag) < 2 || strings.Trim(subtag, "abcdefghijklmnopqrstuvwxyz") != "" {
+				return ""
+			}
+			if replacement, ok := deprecatedLanguages[subtag]; ok {
+				subtags[i] = replacement
+			}
+		case len(subtags[i-1]) == 1:
+			// Subtags after a singleton (extensions, private use) keep lowercase.
+			return strings.Join(subtags, "-")
+		case len(subtag) == 4 && i == 1:
+			subtags[i] = strings.ToUpper(subtag[:1]) + subtag[1:]
+		case len(subtag) == 2, len(subtag) == 3 && strings.Trim(subtag, "0123456789") == "":
+			subtags[i] = strings.ToUpper(subtag)
+		}
+	}
+	return strings.Join(subtags, "-")
+}
+
+// hashValue returns a hex encoded SHA-256 hash of the salted value.
+func hashValue(salt, value string) string {
+	sum := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomSalt returns a random salt for hashes, which are not kept beyond the process.
+func randomSalt() (string, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+// truncateString cuts the string to at most maxLength runes.
+func truncateString(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+
+	runes := []rune(value)
+	if len(runes) <= maxLength {
+		return value
+	}
+	return string(runes[:maxLength])
 }
 
 func extractRemoteIP(req *http.Request) string {
@@ -1,8 +1,15 @@
 package traefik_umami_feeder
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -22,9 +29,8 @@ type Website struct {
 	CreatedAt time.Time `json:"createdAt,omitempty"`
 }
 
-func createWebsite(ctx context.Context, umamiHost, umamiToken, teamId, websiteDomain string) (*Website, error) {
-	headers := make(http.Header)
-	headers.Set("Authorization", "Bearer "+umamiToken)
+func createWebsite(ctx context.Context, umamiHost string, headers http.Header, umamiToken, teamId, websiteDomain string) (*Website, error) {
+	headers = withAuthorization(headers, umamiToken)
 
 	var result Website
 	err := sendRequestAndParse(ctx, umamiHost+"/api/websites", Website{
@@ -39,9 +45,8 @@ func createWebsite(ctx context.Context, umamiHost, umamiToken, teamId, websiteDo
 	return &result, nil
 }
 
-func fetchWebsites(ctx context.Context, umamiHost, umamiToken, teamId string) (*[]Website, error) {
-	headers := make(http.Header)
-	headers.Set("Authorization", "Bearer "+umamiToken)
+func fetchWebsites(ctx context.Context, umamiHost string, headers http.Header, umamiToken, teamId string) (*[]Website, error) {
+	headers = withAuthorization(headers, umamiToken)
 
 	url := umamiHost + "/api/websites?pageSize=200"
 	if len(teamId) != 0 {
@@ -66,6 +71,11 @@ func getWebsiteId(h *UmamiFeeder, hostname string) string {
 		return websiteId
 	}
 
+	if !h.isManagedHost(hostname) {
+		h.debugf("website '%s' is collected by %s, it can't be created", hostname, h.collectHost(hostname))
+		return ""
+	}
+
 	h.websitesMutex.Lock()
 	defer h.websitesMutex.Unlock()
 
@@ -78,7 +88,7 @@ func getWebsiteId(h *UmamiFeeder, hostname string) string {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	website, err := createWebsite(ctx, h.umamiHost, h.umamiToken, h.umamiTeamId, hostname)
+	website, err := createWebsite(ctx, h.umamiHost, h.headers, h.umamiToken, h.umamiTeamId, hostname)
 	if err != nil {
 		h.error("failed to create website: " + err.Error())
 		return ""
@@ -86,5 +96,281 @@ func getWebsiteId(h *UmamiFeeder, hostname string) string {
 
 	h.websites[website.Domain] = website.ID
 	h.debugf("website created '%s': %s", website.Domain, website.ID)
+
+	if len(h.reports) > 0 {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if err := syncReports(ctx, h, website.ID, website.Domain); err != nil {
+				h.error("failed to sync reports for " + website.Domain + ": " + err.Error())
+			}
+		}()
+	}
 	return website.ID
 }
+
+// ReportConfig defines a goals or funnel report, which is provisioned in Umami for websites.
+type ReportConfig struct {
+	// Name of the report, used to find an existing report in Umami.
+	Name string `json:"name"`
+	// Description of the report.
+	Description string `json:"description"`
+	// Type of the report, either `goals` or `funnel`.
+	Type string `json:"type"`
+	// Domains limits the report to the given domains, if empty the report is created for all websites.
+	Domains []string `json:"domains"`
+	// Goals is a list of conversion goals, used by `goals` reports.
+	Goals []GoalConfig `json:"goals"`
+	// Steps is an ordered list of funnel steps, used by `funnel` reports.
+	Steps []GoalConfig `json:"steps"`
+	// Window is the time window of a funnel in minutes.
+	Window int `json:"window"`
+}
+
+// GoalConfig defines a single goal or a funnel step.
+type GoalConfig struct {
+	// Type is either `url` (a pageview of the given path) or `event` (a custom event with the given name).
+	Type string `json:"type"`
+	// Value is the path or the event name.
+	Value string `json:"value"`
+	// Goal is the target count of conversions, used by `goals` reports.
+	Goal int `json:"goal"`
+}
+
+func (r *ReportConfig) verify() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+
+	var items []GoalConfig
+	switch r.Type {
+	case "goals":
+		items = r.Goals
+	case "funnel":
+		items = r.Steps
+		if len(items) < 2 {
+			return errors.New("funnel requires at least two steps")
+		}
+	default:
+		return fmt.Errorf("unknown type %s", r.Type)
+	}
+
+	if len(items) == 0 {
+		return errors.New("at least one goal is required")
+	}
+	for _, item := range items {
+		if item.Type != "url" && item.Type != "event" {
+			return fmt.Errorf("unknown goal type %s", item.Type)
+		}
+		if item.Value == "" {
+			return errors.New("goal value is required")
+		}
+	}
+
+	return nil
+}
+
+func (r *ReportConfig) appliesTo(domain string) bool {
+	if len(r.Domains) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(r.Domains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
+}
+
+func (r *ReportConfig) toReport(websiteId string) Report {
+	parameters := map[string]any{}
+	switch r.Type {
+	case "goals":
+		goals := make([]map[string]any, 0, len(r.Goals))
+		for _, goal := range r.Goals {
+			target := goal.Goal
+			if target <= 0 {
+				target = 1
+			}
+			goals = append(goals, map[string]any{"type": goal.Type, "value": goal.Value, "goal": target})
+		}
+		parameters["goals"] = goals
+	case "funnel":
+		steps := make([]map[string]any, 0, len(r.Steps))
+		for _, step := range r.Steps {
+			steps = append(steps, map[string]any{"type": step.Type, "value": step.Value})
+		}
+		window := r.Window
+		if window <= 0 {
+			window = 60
+		}
+		parameters["steps"] = steps
+		parameters["window"] = window
+	}
+
+	return Report{
+		WebsiteId:   websiteId,
+		Type:        r.Type,
+		Name:        r.Name,
+		Description: r.Description,
+		Parameters:  parameters,
+	}
+}
+
+type reportsResponse struct {
+	Data     []Report `json:"data"`
+	Count    int      `json:"count"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"pageSize"`
+}
+
+type Report struct {
+	ID          string         `json:"id,omitempty"`
+	WebsiteId   string         `json:"websiteId,omitempty"`
+	Type        string         `json:"type,omitempty"`
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+func fetchReports(ctx context.Context, umamiHost string, headers http.Header, umamiToken, websiteId string) (*[]Report, error) {
+	headers = withAuthorization(headers, umamiToken)
+
+	var result reportsResponse
+	err := sendRequestAndParse(ctx, umamiHost+"/api/reports?pageSize=200&websiteId="+url.QueryEscape(websiteId), nil, headers, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.Data, nil
+}
+
+func createReport(ctx context.Context, umamiHost string, headers http.Header, umamiToken string, report Report) (*Report, error) {
+	headers = withAuthorization(headers, umamiToken)
+
+	var result Report
+	err := sendRequestAndParse(ctx, umamiHost+"/api/reports", report, headers, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func updateReport(ctx context.Context, umamiHost string, headers http.Header, umamiToken string, report Report) (*Report, error) {
+	headers = withAuthorization(headers, umamiToken)
+
+	var result Report
+	err := sendRequestAndParse(ctx, umamiHost+"/api/reports/"+report.ID, report, headers, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// syncReports makes sure that every configured report applicable to the domain exists in Umami and is up to date.
+// Reports are matched by type and name, reports which are not declared in the configuration are left untouched.
+func syncReports(ctx context.Context, h *UmamiFeeder, websiteId, domain string) error {
+	desired := make([]Report, 0, len(h.reports))
+	for _, report := range h.reports {
+		if report.verify() == nil && report.appliesTo(domain) {
+			desired = append(desired, report.toReport(websiteId))
+		}
+	}
+	if len(desired) == 0 {
+		return nil
+	}
+
+	existing, err := fetchReports(ctx, h.umamiHost, h.headers, h.umamiToken, websiteId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch reports: %w", err)
+	}
+
+	for _, report := range desired {
+		idx := slices.IndexFunc(*existing, func(r Report) bool {
+			return r.Type == report.Type && r.Name == report.Name
+		})
+
+		if idx == -1 {
+			created, err := createReport(ctx, h.umamiHost, h.headers, h.umamiToken, report)
+			if err != nil {
+				return fmt.Errorf("failed to create report '%s': %w", report.Name, err)
+			}
+			h.debugf("report created '%s' for %s: %s", created.Name, domain, created.ID)
+			continue
+		}
+
+		current := (*existing)[idx]
+		if current.Description == report.Description && reportParametersEqual(current.Parameters, report.Parameters) {
+			continue
+		}
+
+		report.ID = current.ID
+		if _, err := updateReport(ctx, h.umamiHost, h.headers, h.umamiToken, report); err != nil {
+			return fmt.Errorf("failed to update report '%s': %w", report.Name, err)
+		}
+		h.debugf("report updated '%s' for %s: %s", report.Name, domain, report.ID)
+	}
+
+	return nil
+}
+
+// reportParametersEqual compares only the parameters managed by the plugin,
+// so the values added by Umami UI (e.g. dateRange) don't cause an update on every sync.
+func reportParametersEqual(current, desired map[string]any) bool {
+	for key, value := range desired {
+		desiredJson, err := json.Marshal(value)
+		if err != nil {
+			return false
+		}
+		currentJson, err := json.Marshal(current[key])
+		if err != nil {
+			return false
+		}
+		if !bytes.Equal(desiredJson, currentJson) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveStaleWebsite evicts the mapping of a domain, whose website ID is unknown to the Umami instance umamiHost,
+// and tries to resolve the domain again by fetching websites or, if allowed, creating a new website.
+// Only websites of UmamiHost can be resolved, as the API token is not valid for other instances. Otherwise, the
+// mapping is kept, as it can't be replaced until restart.
+func resolveStaleWebsite(ctx context.Context, h *UmamiFeeder, umamiHost, hostname, staleId string) string {
+	h.websitesMutex.Lock()
+	if websiteId, ok := h.websites[hostname]; ok && websiteId != staleId {
+		// The mapping was already replaced, e.g. by a previous batch.
+		h.websitesMutex.Unlock()
+		return websiteId
+	}
+	if h.umamiToken == "" || umamiHost != h.umamiHost {
+		h.websitesMutex.Unlock()
+		h.error(fmt.Sprintf("website '%s' is unknown to %s and can't be resolved, check its websiteId: %s", hostname, umamiHost, staleId))
+		return ""
+	}
+	delete(h.websites, hostname)
+	h.websitesMutex.Unlock()
+	h.debugf("website '%s' is unknown to %s, mapping removed: %s", hostname, umamiHost, staleId)
+
+	websites, err := fetchWebsites(ctx, h.umamiHost, h.headers, h.umamiToken, h.umamiTeamId)
+	if err != nil {
+		h.error("failed to fetch websites: " + err.Error())
+	} else {
+		for _, website := range *websites {
+			if website.Domain == hostname && website.ID != staleId {
+				h.websitesMutex.Lock()
+				h.websites[hostname] = website.ID
+				h.websitesMutex.Unlock()
+				h.debugf("website re-fetched '%s': %s", hostname, website.ID)
+				return website.ID
+			}
+		}
+	}
+
+	if h.createNewWebsites {
+		return getWebsiteId(h, hostname)
+	}
+	return ""
+}
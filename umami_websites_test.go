@@ -0,0 +1,75 @@
+package traefik_umami_feeder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSyncReports(t *testing.T) {
+	var created, updated []Report
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/api/reports":
+			_ = json.NewEncoder(rw).Encode(reportsResponse{Data: []Report{
+				{ID: "r1", Type: "goals", Name: "Checkout", Parameters: map[string]any{
+					"goals":     []any{map[string]any{"type": "url", "value": "/checkout/success", "goal": 1}},
+					"dateRange": map[string]any{"value": "30day"},
+				}},
+				{ID: "r2", Type: "funnel", Name: "Signup", Parameters: map[string]any{}},
+			}})
+		case req.Method == http.MethodPost && req.URL.Path == "/api/reports":
+			var report Report
+			_ = json.NewDecoder(req.Body).Decode(&report)
+			created = append(created, report)
+			_ = json.NewEncoder(rw).Encode(report)
+		case req.Method == http.MethodPost:
+			var report Report
+			_ = json.NewDecoder(req.Body).Decode(&report)
+			updated = append(updated, report)
+			_ = json.NewEncoder(rw).Encode(report)
+		}
+	}))
+	defer server.Close()
+
+	feeder := &UmamiFeeder{umamiHost: server.URL, umamiToken: "token", reports: []ReportConfig{
+		{Name: "Checkout", Type: "goals", Goals: []GoalConfig{{Type: "url", Value: "/checkout/success"}}},
+		{Name: "Signup", Type: "funnel", Steps: []GoalConfig{{Type: "url", Value: "/signup"}, {Type: "event", Value: "signup"}}},
+		{Name: "Newsletter", Type: "goals", Goals: []GoalConfig{{Type: "event", Value: "subscribe"}}},
+		{Name: "Other", Type: "goals", Domains: []string{"other.com"}, Goals: []GoalConfig{{Type: "event", Value: "x"}}},
+	}}
+
+	err := syncReports(context.Background(), feeder, "website", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created) != 1 || created[0].Name != "Newsletter" || created[0].WebsiteId != "website" {
+		t.Fatalf("unexpected created reports %v", created)
+	}
+	if len(updated) != 1 || updated[0].ID != "r2" {
+		t.Fatalf("unexpected updated reports %v", updated)
+	}
+}
+
+func TestReportConfigVerify(t *testing.T) {
+	valid := ReportConfig{Name: "Goals", Type: "goals", Goals: []GoalConfig{{Type: "url", Value: "/"}}}
+	if err := valid.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []ReportConfig{
+		{Type: "goals", Goals: []GoalConfig{{Type: "url", Value: "/"}}},
+		{Name: "Goals", Type: "retention"},
+		{Name: "Goals", Type: "goals"},
+		{Name: "Goals", Type: "goals", Goals: []GoalConfig{{Type: "click", Value: "/"}}},
+		{Name: "Funnel", Type: "funnel", Steps: []GoalConfig{{Type: "url", Value: "/"}}},
+	}
+	for _, report := range invalid {
+		if err := report.verify(); err == nil {
+			t.Fatalf("expected error for %v", report)
+		}
+	}
+}
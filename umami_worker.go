@@ -2,9 +2,13 @@ package traefik_umami_feeder
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -18,9 +22,11 @@ type UmamiEvent struct {
 	UserAgent string         `json:"userAgent,omitempty"` // User agent
 	Timestamp int64          `json:"timestamp,omitempty"` // UNIX timestamp in seconds
 	Data      map[string]any `json:"data,omitempty"`      // Additional data for the event
-	// Name      string         `json:"name,omitempty"`      // Event name (for custom events)
-	// Screen    string         `json:"screen,omitempty"`    // Screen resolution (ex. "1920x1080")
-	// Title     string         `json:"title,omitempty"`     // Page title
+	Name      string         `json:"name,omitempty"`      // Event name (for custom events)
+	Title     string         `json:"title,omitempty"`     // Page title
+	Screen    string         `json:"screen,omitempty"`    // Screen resolution (ex. "1920x1080")
+	Id        string         `json:"id,omitempty"`        // Distinct ID of the visitor
+	Tag       string         `json:"tag,omitempty"`       // Tag of the event (ex. "prod")
 }
 
 type SendBody struct {
@@ -28,7 +34,8 @@ type SendBody struct {
 	Type    string      `json:"type"`
 }
 
-func (h *UmamiFeeder) submitToFeed(req *http.Request, statusCode int) {
+func (h *UmamiFeeder) submitToFeed(rw *ResponseWrapper) {
+	req, statusCode := rw.request, rw.statusCode
 	hostname := parseDomainFromHost(req.Host)
 	websiteId := getWebsiteId(h, hostname)
 
@@ -37,30 +44,191 @@ func (h *UmamiFeeder) submitToFeed(req *http.Request, statusCode int) {
 		return
 	}
 
+	trackedURL, pathParams := h.trackedURL(req)
 	event := &UmamiEvent{
 		Hostname:  hostname,
 		Language:  parseAcceptLanguage(req.Header.Get("Accept-Language")),
-		Referrer:  req.Referer(),
-		Url:       req.URL.String(),
+		Referrer:  h.trackedReferrer(req, hostname, websiteId),
+		Url:       trackedURL,
 		Ip:        extractRemoteIP(req),
 		UserAgent: req.Header.Get("User-Agent"),
 		Timestamp: time.Now().Unix(),
 		Website:   websiteId,
 	}
 
+	event.Tag = h.eventTag(hostname)
+
+	claims := h.jwtClaims(req)
+	event.Id = h.distinctId(req, claims)
+
+	if h.preferContentLanguage {
+		if language := parseContentLanguage(rw.Header().Get("Content-Language")); language != "" {
+			event.Language = language
+		}
+	}
+
+	if language, _, ok := h.pathLocale(req.URL.Path); ok {
+		event.Language = language
+	}
+
+	if h.clientHints {
+		event.Screen = parseScreen(req)
+		event.UserAgent = clientHintsUserAgent(req)
+	}
+
+	if h.extractTitle {
+		event.Title = truncateString(rw.title(), maxDataStringLength)
+	}
+
 	if statusCode >= 400 {
 		event.Data = map[string]any{
 			"status_code": statusCode,
 		}
 	}
 
+	if h.pathParamsAsData {
+		for name, value := range pathParams {
+			h.setEventData(event, name, value)
+		}
+	}
+
+	for _, property := range h.dataFromResponse {
+		if value := rw.responseData(property); value != nil {
+			h.setEventData(event, property, value)
+		}
+	}
+	h.setRequestData(event, req, h.dataFromHeaders, h.dataFromCookies)
+	if claims != nil {
+		for claim, property := range h.jwt.Claims {
+			if value := claimValue(claims[claim]); value != nil {
+				h.setEventData(event, property, value)
+			}
+		}
+	}
+
+	// Conversions are reached by the requested URL, they don't carry the redirect event.
+	pageview := *event
+	pageview.Data = maps.Clone(event.Data)
+
+	if isRedirect(statusCode) {
+		switch h.trackRedirects {
+		case trackRedirectsSkip:
+			h.debugf("not reporting %d redirect", statusCode)
+			return
+		case trackRedirectsEvent:
+			event.Name = "redirect"
+			h.setEventData(event, "from", event.Url)
+			h.setEventData(event, "to", h.trackedLocation(rw.Header().Get("Location")))
+			h.setEventData(event, "status_code", statusCode)
+		}
+	}
+
+	if identify := h.identifyEvent(req, claims, event); identify != nil {
+		h.enqueue(&SendBody{Payload: identify, Type: "identify"})
+	}
+
+	backendEvents := h.applyBackendSignals(rw.backend, event)
+	conversions := h.applyConversions(req, statusCode, &pageview)
+	events := append(h.applyEventRules(req, statusCode, event), backendEvents...)
+	if purchase := h.applyPurchaseRule(rw, event); purchase != nil {
+		events = append(events, purchase)
+	}
+	for _, e := range append(events, conversions...) {
+		h.enqueue(&SendBody{Payload: e, Type: "event"})
+	}
+}
+
+func (h *UmamiFeeder) enqueue(body *SendBody) {
+	h.enforceLimits(body.Payload)
+
 	select {
-	case h.queue <- event:
+	case h.queue <- body:
 	default:
 		h.error("failed to submit event: queue full")
 	}
 }
 
+// Limits of event data, values exceeding them are not stored by Umami.
+const (
+	maxDataProperties   = 50
+	maxDataStringLength = 500
+)
+
+// setRequestData adds the request headers and cookies to the event data, as given by the mappings of
+// header or cookie name to property name.
+func (h *UmamiFeeder) setRequestData(event *UmamiEvent, req *http.Request, headers, cookies map[string]string) {
+	for header, property := range headers {
+		if h.jwt != nil && strings.EqualFold(header, h.jwt.Header) {
+			continue // Never forward the raw token.
+		}
+		if value := req.Header.Get(header); value != "" {
+			h.setEventData(event, property, value)
+		}
+	}
+	for name, property := range cookies {
+		if h.jwt != nil && name == h.jwt.Cookie {
+			continue // Never forward the raw token.
+		}
+		if cookie, err := req.Cookie(name); err == nil && cookie.Value != "" {
+			h.setEventData(event, property, cookie.Value)
+		}
+	}
+}
+
+// setEventData adds a property to the event data, hashing and truncating the value if needed.
+// The amount of properties is limited by enforceLimits, so that the same properties are dropped on every request.
+func (h *UmamiFeeder) setEventData(event *UmamiEvent, property string, value any) {
+	if event.Data == nil {
+		event.Data = map[string]any{}
+	}
+
+	if str, ok := value.(string); ok {
+		if slices.Contains(h.hashData, property) {
+			str = hashValue(h.hashSalt, str)
+		}
+		value = truncateString(str, maxDataStringLength)
+	}
+	event.Data[property] = value
+}
+
+// Tags are limited to 50 characters by Umami.
+const maxTagLength = 50
+
+// eventTag returns the tag of the website, or the tag of the middleware.
+func (h *UmamiFeeder) eventTag(hostname string) string {
+	tag := h.tag
+	if websiteTag, ok := h.websiteTags[hostname]; ok {
+		tag = websiteTag
+	}
+	return truncateString(tag, maxTagLength)
+}
+
+// Distinct IDs are limited to 50 characters by Umami, so the hash is shortened to 128 bits.
+const distinctIdLength = 32
+
+// distinctId returns the hashed ID of the visitor, taken from the configured header, cookie or token claim.
+func (h *UmamiFeeder) distinctId(req *http.Request, claims map[string]any) string {
+	var id string
+	if h.distinctIdHeader != "" {
+		id = req.Header.Get(h.distinctIdHeader)
+	}
+	if id == "" && h.distinctIdCookie != "" {
+		if cookie, err := req.Cookie(h.distinctIdCookie); err == nil {
+			id = cookie.Value
+		}
+	}
+	if id == "" && claims != nil {
+		if value := claimValue(claims[h.jwt.DistinctIdClaim]); value != nil {
+			id = fmt.Sprint(value)
+		}
+	}
+	if id == "" {
+		return ""
+	}
+
+	return hashValue(h.hashSalt, id)[:distinctIdLength]
+}
+
 func (h *UmamiFeeder) startWorker(ctx context.Context) {
 	for {
 		err := h.umamiEventFeeder(ctx)
@@ -94,8 +262,8 @@ func (h *UmamiFeeder) umamiEventFeeder(ctx context.Context) error {
 			}
 			return nil
 
-		case event := <-h.queue:
-			batch = append(batch, &SendBody{Payload: event, Type: "event"})
+		case body := <-h.queue:
+			batch = append(batch, body)
 			if len(batch) >= h.batchSize {
 				h.reportEventsToUmami(ctx, batch)
 				batch = make([]*SendBody, 0, h.batchSize)
@@ -113,17 +281,186 @@ func (h *UmamiFeeder) umamiEventFeeder(ctx context.Context) error {
 }
 
 func (h *UmamiFeeder) reportEventsToUmami(ctx context.Context, events []*SendBody) {
-	h.debugf("reporting %d events", len(events))
-	resp, err := sendRequest(ctx, h.umamiHost+"/api/batch", events, nil)
-	if err != nil {
-		h.error("failed to send tracking: " + err.Error())
+	if len(h.websiteHosts) == 0 {
+		h.sendBatchWithRetry(ctx, h.umamiHost, events)
 		return
 	}
-	if h.isDebug {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		h.debugf("%v: %s", resp.Status, string(bodyBytes))
+
+	// Group events per Umami instance, preserving the order of hosts as they appear in the batch.
+	var hosts []string
+	batches := make(map[string][]*SendBody)
+	for _, event := range events {
+		host := h.collectHost(event.Payload.Hostname)
+		if _, ok := batches[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		batches[host] = append(batches[host], event)
+	}
+
+	for _, host := range hosts {
+		h.sendBatchWithRetry(ctx, host, batches[host])
+	}
+}
+
+// sendBatchWithRetry sends the batch and, if some events were rejected because Umami doesn't know their website,
+// resolves the websites again and resends the affected events once.
+func (h *UmamiFeeder) sendBatchWithRetry(ctx context.Context, umamiHost string, events []*SendBody) {
+	capabilities := h.capabilitiesFor(umamiHost)
+	events = preparePayloads(events, capabilities)
+	if len(events) == 0 {
+		return
+	}
+
+	rejected := h.send(ctx, umamiHost, events)
+	if len(rejected) == 0 {
+		return
+	}
+
+	resolved := h.resolveRejectedEvents(ctx, umamiHost, rejected)
+	if len(resolved) > 0 {
+		h.send(ctx, umamiHost, resolved)
+	}
+}
+
+// send submits the events at once, or one by one if the Umami instance has no batch API.
+// Returns the events, which were rejected because their website is unknown.
+func (h *UmamiFeeder) send(ctx context.Context, umamiHost string, events []*SendBody) []*SendBody {
+	if !h.capabilitiesFor(umamiHost).Batch {
+		return h.sendEvents(ctx, umamiHost, events)
+	}
+	return h.sendBatch(ctx, umamiHost, events)
+}
+
+// preparePayloads removes the payloads and fields, which are not supported by the Umami instance.
+func preparePayloads(events []*SendBody, capabilities *umamiCapabilities) []*SendBody {
+	prepared := events[:0]
+	for _, event := range events {
+		if event.Type == "identify" && !capabilities.Identify {
+			continue
+		}
+		if !capabilities.DistinctIds {
+			event.Payload.Id = ""
+		}
+		if !capabilities.Tags {
+			event.Payload.Tag = ""
+		}
+		prepared = append(prepared, event)
+	}
+	return prepared
+}
+
+// resolveRejectedEvents assigns a new website ID to the events, which were rejected by umamiHost due to an unknown
+// website. Events whose website cannot be resolved are dropped.
+func (h *UmamiFeeder) resolveRejectedEvents(ctx context.Context, umamiHost string, events []*SendBody) []*SendBody {
+	resolvedIds := make(map[string]string)
+	resolved := make([]*SendBody, 0, len(events))
+	for _, event := range events {
+		hostname, staleId := event.Payload.Hostname, event.Payload.Website
+		websiteId, ok := resolvedIds[hostname]
+		if !ok {
+			websiteId = resolveStaleWebsite(ctx, h, umamiHost, hostname, staleId)
+			resolvedIds[hostname] = websiteId
+		}
+
+		if websiteId == "" || websiteId == staleId {
+			h.error("tracking skipped, website is unknown to Umami: " + hostname)
+			continue
+		}
+
+		event.Payload.Website = websiteId
+		resolved = append(resolved, event)
+	}
+	return resolved
+}
+
+type batchResponse struct {
+	Size      int                `json:"size"`
+	Processed int                `json:"processed"`
+	Errors    int                `json:"errors"`
+	Details   []batchErrorDetail `json:"details"`
+}
+
+type batchErrorDetail struct {
+	Index    int             `json:"index"`
+	Response json.RawMessage `json:"response"`
+}
+
+// sendBatch submits events to Umami and returns the events, which were rejected because their website is unknown.
+func (h *UmamiFeeder) sendBatch(ctx context.Context, umamiHost string, events []*SendBody) []*SendBody {
+	h.debugf("reporting %d events to %s", len(events), umamiHost)
+	resp, err := sendRequest(ctx, umamiHost+"/api/batch", events, h.headers.Clone())
+	if err != nil && isBatchUnsupported(err) {
+		h.infof("Umami %s has no batch API, sending events one by one", umamiHost)
+		h.disableBatch(umamiHost)
+		return h.sendEvents(ctx, umamiHost, events)
+	}
+	if err != nil {
+		h.error("failed to send tracking: " + err.Error())
+		return nil
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.error("failed to read tracking response: " + err.Error())
+		return nil
+	}
+	h.debugf("%v: %s", resp.Status, string(bodyBytes))
+
+	var result batchResponse
+	if err := json.Unmarshal(bodyBytes, &result); err != nil || result.Errors == 0 {
+		return nil
+	}
+
+	var rejected []*SendBody
+	for _, detail := range result.Details {
+		if detail.Index < 0 || detail.Index >= len(events) {
+			continue
+		}
+
+		if isWebsiteNotFound(detail.Response) {
+			rejected = append(rejected, events[detail.Index])
+		} else {
+			h.error(fmt.Sprintf("event rejected by Umami: %s", string(detail.Response)))
+		}
+	}
+	return rejected
+}
+
+func isWebsiteNotFound(response []byte) bool {
+	return strings.Contains(strings.ToLower(string(response)), "website not found")
+}
+
+// sendEvents submits events one by one, used for Umami instances without batch API.
+// Returns the events, which were rejected because their website is unknown.
+func (h *UmamiFeeder) sendEvents(ctx context.Context, umamiHost string, events []*SendBody) []*SendBody {
+	h.debugf("reporting %d events one by one to %s", len(events), umamiHost)
+
+	var rejected []*SendBody
+	for _, event := range events {
+		// Older versions don't read the client from the payload, but from the request headers.
+		headers := h.headers.Clone()
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		headers.Set("User-Agent", event.Payload.UserAgent)
+		if event.Payload.Ip != "" {
+			headers.Set("X-Forwarded-For", event.Payload.Ip)
+		}
+
+		resp, err := sendRequest(ctx, umamiHost+"/api/send", event, headers)
+		if err != nil {
+			if isWebsiteNotFound([]byte(err.Error())) {
+				rejected = append(rejected, event)
+				continue
+			}
+
+			h.error("failed to send tracking: " + err.Error())
+			continue
+		}
+		_ = resp.Body.Close()
+	}
+	return rejected
 }
@@ -0,0 +1,281 @@
+package traefik_umami_feeder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]*SendBody
+}
+
+func (r *batchRecorder) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var batch []*SendBody
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		r.batches = append(r.batches, batch)
+		r.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestReportEventsPerHost(t *testing.T) {
+	us, eu := &batchRecorder{}, &batchRecorder{}
+	usServer, euServer := us.server(t), eu.server(t)
+
+	cfg := CreateConfig()
+	cfg.Enabled = false
+	cfg.UmamiHost = usServer.URL
+	cfg.Websites = map[string]string{"example.eu": "eu-website"}
+	cfg.WebsiteHosts = map[string]string{"Example.EU": euServer.URL + "/"}
+
+	handler, err := New(context.Background(), nil, cfg, "umami-feeder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder := handler.(*UmamiFeeder)
+	feeder.reportEventsToUmami(context.Background(), []*SendBody{
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.com"}},
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.eu"}},
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.org"}},
+	})
+
+	if len(us.batches) != 1 || len(us.batches[0]) != 2 {
+		t.Fatalf("expected one batch of 2 events for default host, got %v", us.batches)
+	}
+	if len(eu.batches) != 1 || len(eu.batches[0]) != 1 || eu.batches[0][0].Payload.Hostname != "example.eu" {
+		t.Fatalf("expected one batch of 1 event for overridden host, got %v", eu.batches)
+	}
+}
+
+func TestInvalidWebsiteHost(t *testing.T) {
+	feeder := &UmamiFeeder{websiteHosts: map[string]string{}}
+	err := feeder.verifyConfig(&Config{WebsiteHosts: map[string]string{"example.eu": "eu.umami"}})
+	if err == nil {
+		t.Fatal("should have failed with invalid host")
+	}
+
+	err = feeder.verifyConfig(&Config{WebsiteHosts: map[string]string{"example.eu": "https://eu.umami"}, CreateNewWebsites: true})
+	if err == nil {
+		t.Fatal("should have failed without websiteId of the overridden host")
+	}
+}
+
+func TestConnectSkipsOverriddenHosts(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requested = append(requested, req.Method+" "+req.URL.RequestURI())
+		mu.Unlock()
+
+		switch req.URL.Path {
+		case "/api/websites":
+			_ = json.NewEncoder(rw).Encode(websitesResponse{Data: []Website{
+				{ID: "us-website", Domain: "example.com"},
+				{ID: "us-copy", Domain: "example.eu"},
+			}})
+		case "/api/reports":
+			if req.Method == http.MethodGet {
+				_ = json.NewEncoder(rw).Encode(reportsResponse{})
+				return
+			}
+			_, _ = rw.Write([]byte(`{}`))
+		default:
+			_, _ = rw.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	euServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{}`))
+	}))
+	t.Cleanup(euServer.Close)
+
+	cfg := CreateConfig()
+	cfg.Enabled = false
+	cfg.UmamiHost = server.URL
+	cfg.UmamiToken = "token"
+	cfg.Websites = map[string]string{"example.eu": "eu-website"}
+	cfg.WebsiteHosts = map[string]string{"example.eu": euServer.URL}
+	cfg.Reports = []ReportConfig{{Name: "Checkout", Type: "goals", Goals: []GoalConfig{{Type: "url", Value: "/checkout"}}}}
+
+	handler, err := New(context.Background(), nil, cfg, "umami-feeder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder := handler.(*UmamiFeeder)
+	if err := feeder.connect(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if feeder.websites["example.eu"] != "eu-website" || feeder.websites["example.com"] != "us-website" {
+		t.Fatalf("unexpected websites %v", feeder.websites)
+	}
+	syncedUs := false
+	for _, request := range requested {
+		if strings.Contains(request, "eu-website") || strings.Contains(request, "us-copy") {
+			t.Fatalf("expected the overridden website not to be synced with the default host, got %s", request)
+		}
+		syncedUs = syncedUs || request == "GET /api/reports?pageSize=200&websiteId=us-website"
+	}
+	if !syncedUs {
+		t.Fatalf("expected reports of the default host to be synced, got %v", requested)
+	}
+}
+
+func TestGetWebsiteIdOfOverriddenHost(t *testing.T) {
+	created := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		created = true
+	}))
+	t.Cleanup(server.Close)
+
+	feeder := &UmamiFeeder{
+		umamiHost:         server.URL,
+		umamiToken:        "token",
+		createNewWebsites: true,
+		websites:          map[string]string{},
+		websiteHosts:      map[string]string{"example.eu": "https://eu.umami"},
+	}
+	if websiteId := getWebsiteId(feeder, "example.eu"); websiteId != "" || created {
+		t.Fatalf("expected no website to be created on the default host, got %s", websiteId)
+	}
+}
+
+func TestReportEventsResolvesUnknownWebsite(t *testing.T) {
+	var mu sync.Mutex
+	var accepted []*SendBody
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/websites":
+			_ = json.NewEncoder(rw).Encode(websitesResponse{Data: []Website{{ID: "fresh", Domain: "example.com"}}})
+		case "/api/batch":
+			var batch []*SendBody
+			_ = json.NewDecoder(req.Body).Decode(&batch)
+
+			result := batchResponse{Size: len(batch)}
+			mu.Lock()
+			for i, event := range batch {
+				if event.Payload.Website == "stale" {
+					result.Errors++
+					result.Details = append(result.Details, batchErrorDetail{Index: i, Response: json.RawMessage(`{"error":{"message":"Website not found."}}`)})
+					continue
+				}
+				accepted = append(accepted, event)
+			}
+			mu.Unlock()
+			_ = json.NewEncoder(rw).Encode(result)
+		}
+	}))
+	defer server.Close()
+
+	feeder := &UmamiFeeder{
+		umamiHost:  server.URL,
+		umamiToken: "token",
+		websites:   map[string]string{"example.com": "stale", "example.org": "valid"},
+	}
+
+	feeder.reportEventsToUmami(context.Background(), []*SendBody{
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.com", Website: "stale", Url: "/a"}},
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.org", Website: "valid", Url: "/b"}},
+		{Type: "event", Payload: &UmamiEvent{Hostname: "example.com", Website: "stale", Url: "/c"}},
+	})
+
+	if len(accepted) != 3 {
+		t.Fatalf("expected all events to be accepted, got %d", len(accepted))
+	}
+	if feeder.websites["example.com"] != "fresh" {
+		t.Fatalf("expected mapping to be updated, got %s", feeder.websites["example.com"])
+	}
+}
+
+func TestResolveStaleWebsiteOfOverriddenHost(t *testing.T) {
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fetched = true
+		_ = json.NewEncoder(rw).Encode(websitesResponse{Data: []Website{{ID: "us-website", Domain: "example.eu"}}})
+	}))
+	t.Cleanup(server.Close)
+
+	feeder := &UmamiFeeder{
+		umamiHost:    server.URL,
+		umamiToken:   "token",
+		websites:     map[string]string{"example.eu": "stale"},
+		websiteHosts: map[string]string{"example.eu": "https://eu.umami"},
+	}
+	if websiteId := resolveStaleWebsite(context.Background(), feeder, "https://eu.umami", "example.eu", "stale"); websiteId != "" {
+		t.Fatalf("expected website of another instance not to be resolved, got %s", websiteId)
+	}
+	if fetched {
+		t.Fatal("expected websites not to be fetched from the default host")
+	}
+	if feeder.websites["example.eu"] != "stale" {
+		t.Fatal("expected mapping to be kept, as it can't be resolved again")
+	}
+}
+
+func TestResolveStaleWebsiteWithoutToken(t *testing.T) {
+	feeder := &UmamiFeeder{umamiHost: "https://umami", websites: map[string]string{"example.com": "stale"}}
+	if websiteId := resolveStaleWebsite(context.Background(), feeder, "https://umami", "example.com", "stale"); websiteId != "" {
+		t.Fatalf("expected website not to be resolved without token, got %s", websiteId)
+	}
+	if feeder.websites["example.com"] != "stale" {
+		t.Fatal("expected mapping to be kept, as it can't be resolved again")
+	}
+}
+
+func TestReportEventsWithHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.Enabled = false
+	cfg.UmamiHost = server.URL
+	cfg.UmamiHeaders = map[string]string{"CF-Access-Client-Id": "client", "CF-Access-Client-Secret": "secret"}
+	cfg.UmamiUserAgent = "my-feeder/1.0"
+
+	handler, err := New(context.Background(), nil, cfg, "umami-feeder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feeder := handler.(*UmamiFeeder)
+	feeder.reportEventsToUmami(context.Background(), []*SendBody{{Type: "event", Payload: &UmamiEvent{Hostname: "example.com"}}})
+
+	if received.Get("Cf-Access-Client-Id") != "client" || received.Get("Cf-Access-Client-Secret") != "secret" {
+		t.Fatalf("expected custom headers, got %v", received)
+	}
+	if received.Get("User-Agent") != "my-feeder/1.0" || received.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", received)
+	}
+}
+
+func TestAuthorizationHeaderConflict(t *testing.T) {
+	feeder := &UmamiFeeder{websiteHosts: map[string]string{}}
+	err := feeder.verifyConfig(&Config{UmamiHeaders: map[string]string{"authorization": "Basic dXNlcjpwYXNz"}, UmamiToken: "token"})
+	if err == nil {
+		t.Fatal("should have failed with Authorization header and umamiToken")
+	}
+	err = feeder.verifyConfig(&Config{UmamiHeaders: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}